@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit actions. Moving a book to the trash is recorded as a delete and
+// taking it out again as a restore; purge is the permanent removal.
+const (
+	actionCreate  = "create"
+	actionUpdate  = "update"
+	actionDelete  = "delete"
+	actionRestore = "restore"
+	actionPurge   = "purge"
+)
+
+// AuditEvent is one change to a book. Seq numbers the events of the whole
+// catalog and doubles as the revision id used to restore a book.
+type AuditEvent struct {
+	Seq     int64         `json:"seq"`
+	BookId  string        `json:"book_id"`
+	Action  string        `json:"action"` // create|update|delete|restore|purge
+	Actor   string        `json:"actor"`
+	Time    time.Time     `json:"time"`
+	Before  *Book         `json:"before,omitempty"`
+	After   *Book         `json:"after,omitempty"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange is the before/after value of one top-level Book field.
+type FieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// auditLog is an append-only NDJSON file of AuditEvents. Events are
+// appended after the store change succeeded, so a crash in between can
+// lose an event but never records a change that did not happen.
+type auditLog struct {
+	mu        sync.Mutex
+	path      string
+	lastSeq   int64
+	listeners []func(AuditEvent) // see subscribe
+}
+
+// audit is opened in main; when nil nothing is recorded.
+var audit *auditLog
+
+// openAuditLog opens (or creates) the log at path and resumes its
+// sequence numbers.
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{path: path}
+	events, err := a.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	if len(events) > 0 {
+		a.lastSeq = events[len(events)-1].Seq
+	}
+	return a, nil
+}
+
+// record appends an event for the change from before to after; either may
+// be nil for creates and deletes. Updates that change nothing are skipped.
+func (a *auditLog) record(action, actor string, before, after *Book) {
+	a.recordAll(actor, []auditChange{{action, before, after}})
+}
+
+// auditChange is one change passed to recordAll.
+type auditChange struct {
+	action        string
+	before, after *Book
+}
+
+// recordAll is record for several changes, which are appended with a
+// single write.
+func (a *auditLog) recordAll(actor string, changes []auditChange) {
+	if a == nil {
+		return
+	}
+	now := time.Now().UTC()
+	events := make([]AuditEvent, 0, len(changes))
+	for _, c := range changes {
+		diff := diffBooks(c.before, c.after)
+		if c.action == actionUpdate && len(diff) == 0 {
+			continue
+		}
+		ev := AuditEvent{Action: c.action, Actor: actor, Time: now, Before: c.before, After: c.after, Changes: diff}
+		if c.after != nil {
+			ev.BookId = c.after.Id
+		} else {
+			ev.BookId = c.before.Id
+		}
+		events = append(events, ev)
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range events {
+		events[i].Seq = a.lastSeq + 1 + int64(i)
+	}
+	if err := a.append(events); err != nil {
+		log.Printf("Error - audit log: %v", err)
+		return
+	}
+	a.lastSeq = events[len(events)-1].Seq
+	for _, ev := range events {
+		for _, fn := range a.listeners {
+			fn(ev)
+		}
+	}
+}
+
+// seq returns the seq of the last event recorded.
+func (a *auditLog) seq() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastSeq
+}
+
+// subscribe calls fn with every event recorded from now on, in order. fn
+// runs while the log is locked and must not block.
+func (a *auditLog) subscribe(fn func(AuditEvent)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.listeners = append(a.listeners, fn)
+}
+
+func (a *auditLog) append(events []AuditEvent) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// history returns the events of one book, oldest first.
+func (a *auditLog) history(bookId string) ([]AuditEvent, error) {
+	if a == nil {
+		return nil, nil
+	}
+	events, err := a.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return slices.DeleteFunc(events, func(ev AuditEvent) bool { return ev.BookId != bookId }), nil
+}
+
+func (a *auditLog) read() ([]AuditEvent, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []AuditEvent{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var ev AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("audit log line %d: %w", len(events)+1, err)
+		}
+		events = append(events, ev)
+	}
+	return events, sc.Err()
+}
+
+// diffBooks lists the top-level JSON fields that differ between before
+// and after.
+func diffBooks(before, after *Book) []FieldChange {
+	fields := func(b *Book) map[string]json.RawMessage {
+		m := map[string]json.RawMessage{}
+		if b != nil {
+			data, _ := json.Marshal(b)
+			json.Unmarshal(data, &m)
+		}
+		return m
+	}
+	bm, am := fields(before), fields(after)
+
+	var names []string
+	for name := range bm {
+		names = append(names, name)
+	}
+	for name := range am {
+		if _, ok := bm[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var changes []FieldChange
+	for _, name := range names {
+		if !bytes.Equal(bm[name], am[name]) {
+			changes = append(changes, FieldChange{name, bm[name], am[name]})
+		}
+	}
+	return changes
+}
+
+// auditedStore records every book change made through it in the audit
+// log under the given actor.
+type auditedStore struct {
+	BookStore
+	actor string
+}
+
+// storeAs returns the store to use for changes made on behalf of actor.
+func storeAs(actor string) BookStore {
+	return auditedStore{store, actor}
+}
+
+func (s auditedStore) Create(book Book) error {
+	if err := s.BookStore.Create(book); err != nil {
+		return err
+	}
+	audit.record(actionCreate, s.actor, nil, &book)
+	return nil
+}
+
+func (s auditedStore) Update(book Book) error {
+	before, err := s.BookStore.Get(book.Id)
+	if err != nil {
+		return err
+	}
+	if err := s.BookStore.Update(book); err != nil {
+		return err
+	}
+	action := actionUpdate
+	switch {
+	case !before.Deleted() && book.Deleted():
+		action = actionDelete
+	case before.Deleted() && !book.Deleted():
+		action = actionRestore
+	}
+	audit.record(action, s.actor, &before, &book)
+	return nil
+}
+
+func (s auditedStore) Delete(id string) error {
+	before, err := s.BookStore.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := s.BookStore.Delete(id); err != nil {
+		return err
+	}
+	audit.record(actionPurge, s.actor, &before, nil)
+	return nil
+}
+
+func (s auditedStore) PlaceOrder(order Order, books []Book, cartId string) error {
+	befores := make([]Book, len(books))
+	for i, book := range books {
+		before, err := s.BookStore.Get(book.Id)
+		if err != nil {
+			return err
+		}
+		befores[i] = before
+	}
+	if err := s.BookStore.PlaceOrder(order, books, cartId); err != nil {
+		return err
+	}
+	changes := make([]auditChange, len(books))
+	for i := range books {
+		changes[i] = auditChange{actionUpdate, &befores[i], &books[i]}
+	}
+	audit.recordAll(s.actor, changes)
+	return nil
+}
+
+func (s auditedStore) SaveBatch(authors []Author, create, update []Book) error {
+	befores := make([]Book, len(update))
+	for i, book := range update {
+		before, err := s.BookStore.Get(book.Id)
+		if err != nil {
+			return err
+		}
+		befores[i] = before
+	}
+	if err := s.BookStore.SaveBatch(authors, create, update); err != nil {
+		return err
+	}
+	changes := make([]auditChange, 0, len(create)+len(update))
+	for i := range create {
+		changes = append(changes, auditChange{actionCreate, nil, &create[i]})
+	}
+	for i := range update {
+		changes = append(changes, auditChange{actionUpdate, &befores[i], &update[i]})
+	}
+	audit.recordAll(s.actor, changes)
+	return nil
+}
+
+// handleBookHistory lists the audit events of a book, including deleted
+// books.
+func handleBookHistory(w http.ResponseWriter, r *http.Request) {
+	events, err := audit.history(r.PathValue("id"))
+	if err != nil {
+		logError(r, err)
+		writeError(w, 500, "Internal server error")
+		return
+	}
+	if len(events) == 0 {
+		writeError(w, 404, "No history for this book")
+		return
+	}
+	writeJSON(w, 200, events)
+}
+
+// handleRestoreRevision puts a book back into the state recorded by the
+// event with the given seq: the state after the change, or the state
+// before a purge. The book is recreated if it was purged and taken out of
+// the trash if it is in there.
+func handleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	seq, err := strconv.ParseInt(r.PathValue("seq"), 10, 64)
+	if err != nil {
+		writeError(w, 400, "Invalid query parameters", FieldError{"seq", "must be an integer"})
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	events, err := audit.history(id)
+	if err != nil {
+		logError(r, err)
+		writeError(w, 500, "Internal server error")
+		return
+	}
+	i := slices.IndexFunc(events, func(ev AuditEvent) bool { return ev.Seq == seq })
+	if i < 0 {
+		writeError(w, 404, "Revision not found")
+		return
+	}
+	revision := events[i].After
+	if revision == nil {
+		revision = events[i].Before
+	}
+	book := *revision
+	book.DeletedAt = time.Time{}
+
+	current, err := store.Get(id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// the reviews were purged with the book
+		book.Rating = RatingSummary{}
+		err = nil
+	case err == nil:
+		if preconditionFailed(w, r, current) {
+			return
+		}
+		// stock and rating are tracked live; restoring metadata must not
+		// rewind them
+		book.Inventory = current.Inventory
+		book.Rating = current.Rating
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	// the author or categories of the revision may be gone by now
+	if fieldErrs := book.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+	fieldErrs, err := linkRelations(&book, current)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+	if current.Id == "" {
+		err = store.Create(book)
+	} else {
+		err = store.Update(book)
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	var before *Book
+	if current.Id != "" {
+		before = &current
+	}
+	audit.record(actionRestore, actorFromContext(r.Context()), before, &book)
+
+	w.Header().Set("ETag", etagOf(book))
+	writeJSON(w, 200, book)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestRestoreRevisionChecksRelations(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/categories", `{"id":"sf","name":"Science Fiction"}`, 201)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99","category_ids":["sf"]}`, 201)
+	send(t, srv, "PATCH", "/books/a", `{"category_ids":[]}`, 200)
+	send(t, srv, "DELETE", "/categories/sf", "", 204)
+
+	events, err := audit.history("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post(t, srv, fmt.Sprintf("/books/a/history/%d/restore", events[0].Seq), "", 400)
+
+	book, err := store.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(book.CategoryIds, "sf") {
+		t.Errorf("restore stored the deleted category: %v", book.CategoryIds)
+	}
+}
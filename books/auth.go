@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+// authConfig guards the server with API keys and HMAC-signed bearer
+// tokens. Write routes (every method but GET, HEAD and OPTIONS) always
+// need a credential with the write scope; read routes need one unless
+// publicReads is set.
+type authConfig struct {
+	keys        map[[sha256.Size]byte]principal // sha256(key) -> owner
+	secret      []byte                          // HMAC key for bearer tokens
+	publicReads bool
+}
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	Subject string
+	Scope   string
+}
+
+// newAuthConfig parses a comma separated list of API keys, each written as
+// [name=]key[:scope] with scope "read" or "write" (the default), e.g.
+// "ci=s3cr3t,dashboard=0p3n:read".
+func newAuthConfig(apiKeys, tokenSecret string, publicReads bool) (*authConfig, error) {
+	a := &authConfig{
+		keys:        make(map[[sha256.Size]byte]principal),
+		secret:      []byte(tokenSecret),
+		publicReads: publicReads,
+	}
+	for _, entry := range strings.Split(apiKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, key = "apikey", entry
+		}
+		scope := scopeWrite
+		if k, s, ok := strings.Cut(key, ":"); ok {
+			key, scope = k, s
+		}
+		if key == "" || (scope != scopeRead && scope != scopeWrite) {
+			return nil, fmt.Errorf("invalid api key entry %q", name)
+		}
+		a.keys[sha256.Sum256([]byte(key))] = principal{Subject: name, Scope: scope}
+	}
+	return a, nil
+}
+
+// enabled reports whether any credential is configured.
+func (a *authConfig) enabled() bool {
+	return len(a.keys) > 0 || len(a.secret) > 0
+}
+
+// middleware rejects requests without a sufficient credential with 401
+// (missing or invalid) or 403 (valid but read-only on a write route).
+// Public reads still identify callers that send a valid credential, e.g.
+// for the review moderation queue. Without any credential configured,
+// which main only allows with -insecure-no-auth, every caller is an
+// anonymous writer.
+func (a *authConfig) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		if !a.enabled() {
+			anonymous := principal{Subject: "anonymous", Scope: scopeWrite}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, anonymous)))
+			return
+		}
+		if !write && a.publicReads {
+			if p, err := a.authenticate(r); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="books"`)
+			writeError(w, 401, "Unauthorized: "+err.Error())
+			return
+		}
+		if write && p.Scope != scopeWrite {
+			writeError(w, 403, "Forbidden: "+p.Subject+" has read-only access")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// authenticate accepts "Authorization: Bearer <token or api key>" or
+// "X-API-Key: <api key>".
+func (a *authConfig) authenticate(r *http.Request) (principal, error) {
+	cred := r.Header.Get("X-API-Key")
+	if cred == "" {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			return principal{}, errors.New("missing credentials")
+		}
+		cred = strings.TrimSpace(auth[7:])
+	}
+
+	if p, ok := a.keys[sha256.Sum256([]byte(cred))]; ok {
+		return p, nil
+	}
+	if len(a.secret) > 0 && strings.Count(cred, ".") == 3 {
+		return verifyToken(a.secret, cred, time.Now())
+	}
+	return principal{}, errors.New("invalid credentials")
+}
+
+// signToken returns a bearer token of the form
+// base64url(subject).scope.expiry.base64url(hmac-sha256).
+func signToken(secret []byte, subject, scope string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject)) + "." +
+		scope + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(secret, payload))
+}
+
+func verifyToken(secret []byte, token string, now time.Time) (principal, error) {
+	i := strings.LastIndexByte(token, '.')
+	payload, sig := token[:i], token[i+1:]
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, tokenMAC(secret, payload)) {
+		return principal{}, errors.New("invalid token signature")
+	}
+
+	parts := strings.Split(payload, ".")
+	subject, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return principal{}, errors.New("malformed token")
+	}
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return principal{}, errors.New("malformed token")
+	}
+	if now.Unix() >= exp {
+		return principal{}, errors.New("token expired")
+	}
+	return principal{Subject: string(subject), Scope: parts[1]}, nil
+}
+
+func tokenMAC(secret []byte, payload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// principalKey is an unexported type to avoid key collisions in context.
+type principalKey struct{}
+
+// hasWriteScope reports whether the caller of r holds a credential with
+// the write scope, e.g. to see configuration served on public GET routes.
+func hasWriteScope(r *http.Request) bool {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return ok && p.Scope == scopeWrite
+}
+
+// actorFromContext returns the subject of the authenticated caller, or
+// "anonymous" when the request was not authenticated.
+func actorFromContext(ctx context.Context) string {
+	if p, ok := ctx.Value(principalKey{}).(principal); ok {
+		return p.Subject
+	}
+	return "anonymous"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	secret := "t0ken-secret"
+	auth, err := newAuthConfig("ci=w1,dash=r1:read", secret, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caller principal
+	h := auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ = r.Context().Value(principalKey{}).(principal)
+	}))
+	now := time.Now()
+	valid := signToken([]byte(secret), "alice", scopeWrite, now.Add(time.Hour))
+	readOnly := signToken([]byte(secret), "bob", scopeRead, now.Add(time.Hour))
+	expired := signToken([]byte(secret), "alice", scopeWrite, now.Add(-time.Minute))
+	forged := signToken([]byte("other secret"), "alice", scopeWrite, now.Add(time.Hour))
+
+	tests := []struct {
+		name, method, header, value string
+		want                        int
+		subject                     string
+	}{
+		{"no credentials", "POST", "", "", 401, ""},
+		{"no credentials on a read", "GET", "", "", 401, ""},
+		{"unknown key", "POST", "X-API-Key", "nope", 401, ""},
+		{"write key", "POST", "X-API-Key", "w1", 200, "ci"},
+		{"write key as bearer", "DELETE", "Authorization", "Bearer w1", 200, "ci"},
+		{"read key on a write route", "PUT", "X-API-Key", "r1", 403, ""},
+		{"read key on a read route", "GET", "X-API-Key", "r1", 200, "dash"},
+		{"token", "POST", "Authorization", "Bearer " + valid, 200, "alice"},
+		{"read-only token on a write route", "PATCH", "Authorization", "Bearer " + readOnly, 403, ""},
+		{"expired token", "POST", "Authorization", "Bearer " + expired, 401, ""},
+		{"token signed with another secret", "POST", "Authorization", "Bearer " + forged, 401, ""},
+		{"malformed token", "POST", "Authorization", "Bearer a.b.c.d", 401, ""},
+	}
+	for _, tt := range tests {
+		caller = principal{}
+		req := httptest.NewRequest(tt.method, "/books", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want || caller.Subject != tt.subject {
+			t.Errorf("%s: status %d as %q, want %d as %q", tt.name, rec.Code, caller.Subject, tt.want, tt.subject)
+		}
+		if rec.Code == 401 && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tt.name)
+		}
+	}
+}
+
+func TestPublicReadsIdentifyCallers(t *testing.T) {
+	auth, err := newAuthConfig("dash=r1:read", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caller principal
+	h := auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ = r.Context().Value(principalKey{}).(principal)
+	}))
+	for _, key := range []string{"", "r1", "wrong"} {
+		caller = principal{}
+		req := httptest.NewRequest("GET", "/books", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		want := ""
+		if key == "r1" {
+			want = "dash"
+		}
+		if rec.Code != 200 || caller.Subject != want {
+			t.Errorf("key %q: status %d as %q, want 200 as %q", key, rec.Code, caller.Subject, want)
+		}
+	}
+}
+
+func TestNewAuthConfigRejectsBadEntries(t *testing.T) {
+	for _, keys := range []string{"ci=", "ci=k:admin", ":read"} {
+		if _, err := newAuthConfig(keys, "", false); err == nil {
+			t.Errorf("newAuthConfig(%q) accepted", keys)
+		}
+	}
+}
+
+func TestConfigRequiresCredentials(t *testing.T) {
+	if _, err := loadConfig(nil); !errors.Is(err, errNoCredentials) {
+		t.Errorf("loadConfig without credentials: %v, want errNoCredentials", err)
+	}
+	for _, args := range [][]string{{"-api-keys", "k"}, {"-token-secret", "s"}, {"-insecure-no-auth"}} {
+		if _, err := loadConfig(args); err != nil {
+			t.Errorf("loadConfig(%q): %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Author is a person books are written by. Books refer to their author by
+// id and keep a copy of the name in Book.Author.
+type Author struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Bio  string `json:"bio,omitempty"`
+}
+
+// Validate reports every field of a that does not satisfy the Author
+// schema.
+func (a Author) Validate() []FieldError {
+	if strings.TrimSpace(a.Name) == "" {
+		return []FieldError{{"name", "is required"}}
+	}
+	return nil
+}
+
+var (
+	// errInUse is returned when an author or category that books still
+	// refer to is deleted.
+	errInUse = errors.New("still referenced by books")
+	// errNameTaken is returned when an author or category is given the
+	// name of another one.
+	errNameTaken = errors.New("name is already taken")
+)
+
+// writeRelationError maps author and category errors to a JSON response.
+func writeRelationError(w http.ResponseWriter, r *http.Request, err error, notFound string) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, 404, notFound)
+	case errors.Is(err, errNameTaken), errors.Is(err, errInUse):
+		writeError(w, 409, err.Error())
+	default:
+		logError(r, err)
+		writeError(w, 500, "Internal server error")
+	}
+}
+
+// findAuthorByName returns the author whose name matches name after
+// normalizeName, or ErrNotFound.
+func findAuthorByName(name string) (Author, error) {
+	authors, err := store.ListAuthors()
+	if err != nil {
+		return Author{}, err
+	}
+	key := normalizeName(name)
+	i := slices.IndexFunc(authors, func(a Author) bool { return normalizeName(a.Name) == key })
+	if i < 0 {
+		return Author{}, ErrNotFound
+	}
+	return authors[i], nil
+}
+
+// checkAuthorName returns errNameTaken if an author other than id already
+// goes by name.
+func checkAuthorName(id, name string) error {
+	other, err := findAuthorByName(name)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return nil
+	case err != nil:
+		return err
+	case other.Id != id:
+		return fmt.Errorf("author %q: %w", other.Name, errNameTaken)
+	}
+	return nil
+}
+
+// checkRelations reports the category ids of book and the author_id that
+// linkRelations would use if they do not exist.
+func checkRelations(book Book, current Book) ([]FieldError, error) {
+	var errs []FieldError
+	for _, id := range book.CategoryIds {
+		_, err := store.GetCategory(id)
+		if errors.Is(err, ErrNotFound) {
+			errs = append(errs, FieldError{"category_ids", "unknown category " + id})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if linksByID(book, current) {
+		_, err := store.GetAuthor(book.AuthorId)
+		if errors.Is(err, ErrNotFound) {
+			errs = append(errs, FieldError{"author_id", "unknown author"})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return errs, nil
+}
+
+// linksByID reports whether book names its author by author_id: it does
+// unless the request only changed the author name.
+func linksByID(book Book, current Book) bool {
+	renamed := book.Author != "" && book.Author != current.Author && book.AuthorId == current.AuthorId
+	return book.AuthorId != "" && !renamed
+}
+
+// linkRelations points book at its Author and Category records. A book
+// without an author_id, or whose author name changed, is linked to the
+// author of that name, which is created if there is none yet; either way
+// Book.Author ends up with the canonical name. current is the stored
+// book, or the zero Book for a new one. Unknown ids are reported as field
+// errors, see checkRelations. The caller must hold mutateMu.
+func linkRelations(book *Book, current Book) ([]FieldError, error) {
+	var l authorLinker
+	if errs, err := l.link(book, current); errs != nil || err != nil {
+		return errs, err
+	}
+	for _, author := range l.created {
+		if err := store.SaveAuthor(author); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// authorLinker links many books like linkRelations, but keeps the authors
+// it creates in memory, so they can be saved together with the books.
+// Books naming the same new author share one record.
+type authorLinker struct {
+	byName  map[string]Author // by normalizeName, loaded on first use
+	created []Author
+}
+
+// link is linkRelations without saving the authors it creates; they are
+// added to l.created.
+func (l *authorLinker) link(book *Book, current Book) ([]FieldError, error) {
+	book.CategoryIds = slices.Compact(slices.Sorted(slices.Values(book.CategoryIds)))
+	if errs, err := checkRelations(*book, current); errs != nil || err != nil {
+		return errs, err
+	}
+
+	if linksByID(*book, current) {
+		author, err := store.GetAuthor(book.AuthorId)
+		if err != nil {
+			return nil, err
+		}
+		book.Author = author.Name
+		return nil, nil
+	}
+	if book.Author == "" {
+		return nil, nil
+	}
+
+	if l.byName == nil {
+		authors, err := store.ListAuthors()
+		if err != nil {
+			return nil, err
+		}
+		l.byName = make(map[string]Author, len(authors))
+		for _, a := range authors {
+			l.byName[normalizeName(a.Name)] = a
+		}
+	}
+	key := normalizeName(book.Author)
+	author, ok := l.byName[key]
+	if !ok {
+		author = Author{Id: newID(), Name: strings.TrimSpace(book.Author)}
+		l.byName[key] = author
+		l.created = append(l.created, author)
+	}
+	book.AuthorId, book.Author = author.Id, author.Name
+	return nil, nil
+}
+
+// usedBy returns the authors l created that one of books links to.
+func (l *authorLinker) usedBy(books []Book) []Author {
+	return slices.DeleteFunc(slices.Clone(l.created), func(a Author) bool {
+		return !slices.ContainsFunc(books, func(b Book) bool { return b.AuthorId == a.Id })
+	})
+}
+
+// migrateAuthors turns the author names of books stored before authors
+// were resources into Author records and links the books to them in one
+// write. It returns the number of books it changed, and writes nothing
+// once every book is linked.
+func migrateAuthors() (int, error) {
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	books, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	books = slices.DeleteFunc(books, func(b Book) bool { return b.AuthorId != "" || b.Author == "" })
+	if len(books) == 0 {
+		return 0, nil
+	}
+
+	var linker authorLinker
+	update := make([]Book, 0, len(books))
+	for _, book := range books {
+		current := book
+		// stale category ids are left for the next edit to report
+		if _, err := linker.link(&book, current); err != nil {
+			return 0, fmt.Errorf("book %s: %w", book.Id, err)
+		}
+		if book.AuthorId != "" {
+			update = append(update, book)
+		}
+	}
+	if len(update) == 0 {
+		return 0, nil
+	}
+	if err := storeAs("migration").SaveBatch(linker.usedBy(update), nil, update); err != nil {
+		return 0, err
+	}
+	return len(update), nil
+}
+
+// referencingBooks returns the books, including those in the trash, for
+// which refers returns true.
+func referencingBooks(refers func(Book) bool) ([]Book, error) {
+	books, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(books, func(b Book) bool { return !refers(b) }), nil
+}
+
+func handleListAuthors(w http.ResponseWriter, r *http.Request) {
+	authors, err := store.ListAuthors()
+	if err != nil {
+		writeRelationError(w, r, err, "Author not found")
+		return
+	}
+	writeJSON(w, 200, authors)
+}
+
+func handleCreateAuthor(w http.ResponseWriter, r *http.Request) {
+	var author Author
+	if err := json.NewDecoder(r.Body).Decode(&author); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	if author.Id == "" {
+		author.Id = newID()
+	}
+	author.Name = strings.TrimSpace(author.Name)
+	if fieldErrs := author.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	if _, err := store.GetAuthor(author.Id); err == nil {
+		writeError(w, 409, "Author already exists")
+		return
+	}
+	if err := checkAuthorName(author.Id, author.Name); err != nil {
+		writeRelationError(w, r, err, "Author not found")
+		return
+	}
+	if err := store.SaveAuthor(author); err != nil {
+		writeRelationError(w, r, err, "Author not found")
+		return
+	}
+	w.Header().Set("Location", "/authors/"+url.PathEscape(author.Id))
+	writeJSON(w, 201, author)
+}
+
+func handleGetAuthor(w http.ResponseWriter, r *http.Request) {
+	author, err := store.GetAuthor(r.PathValue("id"))
+	if err != nil {
+		writeRelationError(w, r, err, "Author not found")
+		return
+	}
+	writeJSON(w, 200, author)
+}
+
+// handleReplaceAuthor overwrites an author. A new name is copied to every
+// book of the author.
+func handleReplaceAuthor(w http.ResponseWriter, r *http.Request) {
+	var author Author
+	if err := json.NewDecoder(r.Body).Decode(&author); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	author.Id = r.PathValue("id")
+	author.Name = strings.TrimSpace(author.Name)
+	if fieldErrs := author.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	current, err := store.GetAuthor(author.Id)
+	if err == nil {
+		err = checkAuthorName(author.Id, author.Name)
+	}
+	// the books carry the name too; rename them in the same write
+	var books []Book
+	if err == nil && author.Name != current.Name {
+		books, err = referencingBooks(func(b Book) bool { return b.AuthorId == author.Id })
+		for i := range books {
+			books[i].Author = author.Name
+		}
+	}
+	if err == nil {
+		err = storeAs(actorFromContext(r.Context())).SaveBatch([]Author{author}, nil, books)
+	}
+	if err != nil {
+		writeRelationError(w, r, err, "Author not found")
+		return
+	}
+	writeJSON(w, 200, author)
+}
+
+// handleDeleteAuthor removes an author no book refers to anymore.
+func handleDeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	books, err := referencingBooks(func(b Book) bool { return b.AuthorId == id })
+	if err == nil && len(books) > 0 {
+		err = fmt.Errorf("author %s: %w (%d)", id, errInUse, len(books))
+	}
+	if err == nil {
+		err = store.DeleteAuthor(id)
+	}
+	if err != nil {
+		writeRelationError(w, r, err, "Author not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handleListAuthorBooks lists the books of an author and accepts the
+// parameters of GET /books.
+func handleListAuthorBooks(w http.ResponseWriter, r *http.Request) {
+	author, err := store.GetAuthor(r.PathValue("id"))
+	if err != nil {
+		writeRelationError(w, r, err, "Author not found")
+		return
+	}
+	query, fieldErrs := parseListQuery(r.URL.Query())
+	if fieldErrs != nil {
+		writeError(w, 400, "Invalid query parameters", fieldErrs...)
+		return
+	}
+	query.authorId = author.Id
+	writeBookList(w, r, query)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestReplaceAuthorRenamesBooks(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/add", `[{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"},
+		{"id":"b","title":"Dune Messiah","author":"frank herbert","price":"8.99"},
+		{"id":"c","title":"Emma","author":"Jane Austen","price":"5.00"}]`, 200)
+	a, err := store.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(t, srv, "DELETE", "/books/b", "", 204)
+
+	send(t, srv, "PUT", "/authors/"+a.AuthorId, `{"name":"Frank P. Herbert"}`, 200)
+	for id, want := range map[string]string{"a": "Frank P. Herbert", "b": "Frank P. Herbert", "c": "Jane Austen"} {
+		if b, err := store.Get(id); err != nil || b.Author != want {
+			t.Errorf("book %s by %q (%v), want %q", id, b.Author, err, want)
+		}
+	}
+	if author, err := store.GetAuthor(a.AuthorId); err != nil || author.Name != "Frank P. Herbert" {
+		t.Errorf("author = %+v, %v", author, err)
+	}
+
+	send(t, srv, "PUT", "/authors/"+a.AuthorId, `{"name":"jane austen"}`, 409)
+	send(t, srv, "PUT", "/authors/nobody", `{"name":"Nobody"}`, 404)
+	if b, _ := store.Get("a"); b.Author != "Frank P. Herbert" {
+		t.Errorf("book a renamed to %q by a rejected replace", b.Author)
+	}
+}
+
+func TestMigrateAuthors(t *testing.T) {
+	_, path := newTestServer(t)
+	legacy := `[{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"},
+		{"id":"b","title":"Dune Messiah","author":" frank herbert","price":"8.99"},
+		{"id":"c","title":"Emma","author":"Jane Austen","price":"5.00"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := migrateAuthors(); n != 3 || err != nil {
+		t.Fatalf("migrateAuthors = %d, %v; want 3 books", n, err)
+	}
+	authors, err := store.ListAuthors()
+	if err != nil || len(authors) != 2 {
+		t.Fatalf("authors %+v (%v), want Frank Herbert and Jane Austen", authors, err)
+	}
+	a, _ := store.Get("a")
+	b, _ := store.Get("b")
+	if a.AuthorId == "" || a.AuthorId != b.AuthorId || b.Author != "Frank Herbert" {
+		t.Errorf("books a %+v and b %+v, want both linked to Frank Herbert", a, b)
+	}
+
+	// linked books are left alone
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := os.Stat(path)
+	if n, err := migrateAuthors(); n != 0 || err != nil {
+		t.Errorf("second migrateAuthors = %d, %v; want nothing", n, err)
+	}
+	after, _ := os.ReadFile(path)
+	if again, _ := os.Stat(path); !bytes.Equal(before, after) || !again.ModTime().Equal(stat.ModTime()) {
+		t.Errorf("%s was rewritten", path)
+	}
+}
@@ -1,68 +1,508 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 )
 
+// store is the catalog back-end selected at startup.
+var store BookStore
+
+// getBooks returns the books that are not in the trash.
 func getBooks() ([]Book, error) {
-	books := []Book{}
-	booksByte, err := os.ReadFile("./books.json")
+	books, err := store.List()
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(booksByte, &books)
+	return slices.DeleteFunc(books, Book.Deleted), nil
+}
+
+func getBookById(id string) (Book, error) {
+	book, err := getLiveBook(id)
+	if errors.Is(err, ErrNotFound) {
+		return Book{}, nil
+	}
+	return book, err
+}
+
+// JSONStore keeps the whole catalog in a single JSON file. The file is
+// parsed once and kept in memory, with the books indexed by id, until it
+// is changed on disk; see Watch. Mutations are serialized so concurrent
+// read-modify-write cycles cannot lose each other's changes.
+type JSONStore struct {
+	mu   sync.Mutex // serializes mutations
+	path string
+
+	cacheMu sync.RWMutex
+	cache   *catalogCache // nil until the file is read and after it changed on disk
+}
+
+// catalogFile is the layout of the JSON file. As long as there is
+// nothing but books the file is written as a plain array of books, the
+// original format, which is also accepted on read.
+type catalogFile struct {
+	Books      []Book     `json:"books"`
+	Authors    []Author   `json:"authors,omitempty"`
+	Categories []Category `json:"categories,omitempty"`
+	Reviews    []Review   `json:"reviews,omitempty"`
+	Carts      []Cart     `json:"carts,omitempty"`
+	Orders     []Order    `json:"orders,omitempty"`
+}
+
+// NewJSONStore returns a store backed by the JSON file at path.
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{path: path}
+}
+
+func (s *JSONStore) Get(id string) (Book, error) {
+	c, err := s.read()
+	if err != nil {
+		return Book{}, err
+	}
+	if i, ok := c.bookIndex[id]; ok {
+		return c.Books[i].clone(), nil
+	}
+	return Book{}, ErrNotFound
+}
+
+func (s *JSONStore) List() ([]Book, error) {
+	c, err := s.read()
 	if err != nil {
 		return nil, err
 	}
-	return books, nil
+	return cloneEach(c.Books), nil
 }
 
-func getBookById(id string) (Book, error) {
-	books, err := getBooks()
-	var requestedBook Book
+func (s *JSONStore) Create(book Book) error {
+	return s.mutate(func(c *catalogFile) error {
+		for _, b := range c.Books {
+			if b.Id == book.Id {
+				return ErrExists
+			}
+		}
+		c.Books = append(c.Books, book)
+		return nil
+	})
+}
+
+func (s *JSONStore) Update(book Book) error {
+	return s.mutate(func(c *catalogFile) error {
+		for i, b := range c.Books {
+			if b.Id == book.Id {
+				c.Books[i] = book
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *JSONStore) Delete(id string) error {
+	return s.mutate(func(c *catalogFile) error {
+		for i, b := range c.Books {
+			if b.Id == id {
+				c.Books = append(c.Books[:i], c.Books[i+1:]...)
+				c.Reviews = slices.DeleteFunc(c.Reviews, func(r Review) bool { return r.BookId == id })
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
 
+func (s *JSONStore) SaveBatch(authors []Author, create, update []Book) error {
+	return s.mutate(func(c *catalogFile) error {
+		index := make(map[string]int, len(c.Books))
+		for i, b := range c.Books {
+			index[b.Id] = i
+		}
+		for _, book := range update {
+			i, ok := index[book.Id]
+			if !ok {
+				return fmt.Errorf("book %s: %w", book.Id, ErrNotFound)
+			}
+			c.Books[i] = book
+		}
+		for _, book := range create {
+			if _, ok := index[book.Id]; ok {
+				return fmt.Errorf("book %s: %w", book.Id, ErrExists)
+			}
+			index[book.Id] = len(c.Books)
+			c.Books = append(c.Books, book)
+		}
+		for _, author := range authors {
+			c.Authors = upsertByID(c.Authors, author, func(a Author) string { return a.Id })
+		}
+		return nil
+	})
+}
+
+func (s *JSONStore) ListAuthors() ([]Author, error) {
+	c, err := s.read()
 	if err != nil {
-		return Book{}, err
+		return nil, err
 	}
+	if c.Authors == nil {
+		return []Author{}, nil
+	}
+	return slices.Clone(c.Authors), nil
+}
+
+func (s *JSONStore) GetAuthor(id string) (Author, error) {
+	c, err := s.read()
+	if err != nil {
+		return Author{}, err
+	}
+	return findByID(c.Authors, id, func(a Author) string { return a.Id })
+}
+
+func (s *JSONStore) SaveAuthor(author Author) error {
+	return s.mutate(func(c *catalogFile) error {
+		c.Authors = upsertByID(c.Authors, author, func(a Author) string { return a.Id })
+		return nil
+	})
+}
+
+func (s *JSONStore) DeleteAuthor(id string) error {
+	return s.mutate(func(c *catalogFile) (err error) {
+		c.Authors, err = removeByID(c.Authors, id, func(a Author) string { return a.Id })
+		return err
+	})
+}
 
-	for _, book := range books {
-		if book.Id == id {
-			requestedBook = book
+func (s *JSONStore) ListCategories() ([]Category, error) {
+	c, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if c.Categories == nil {
+		return []Category{}, nil
+	}
+	return slices.Clone(c.Categories), nil
+}
+
+func (s *JSONStore) GetCategory(id string) (Category, error) {
+	c, err := s.read()
+	if err != nil {
+		return Category{}, err
+	}
+	return findByID(c.Categories, id, func(cat Category) string { return cat.Id })
+}
+
+func (s *JSONStore) SaveCategory(category Category) error {
+	return s.mutate(func(c *catalogFile) error {
+		c.Categories = upsertByID(c.Categories, category, func(cat Category) string { return cat.Id })
+		return nil
+	})
+}
+
+func (s *JSONStore) DeleteCategory(id string) error {
+	return s.mutate(func(c *catalogFile) (err error) {
+		c.Categories, err = removeByID(c.Categories, id, func(cat Category) string { return cat.Id })
+		return err
+	})
+}
+
+func (s *JSONStore) ListReviews(bookId string) ([]Review, error) {
+	c, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	reviews := []Review{}
+	for _, r := range c.Reviews {
+		if bookId == "" || r.BookId == bookId {
+			reviews = append(reviews, r.clone())
 		}
 	}
+	return reviews, nil
+}
+
+func (s *JSONStore) GetReview(id string) (Review, error) {
+	c, err := s.read()
+	if err != nil {
+		return Review{}, err
+	}
+	review, err := findByID(c.Reviews, id, func(r Review) string { return r.Id })
+	return review.clone(), err
+}
 
-	return requestedBook, nil
+func (s *JSONStore) SaveReview(review Review) error {
+	return s.mutate(func(c *catalogFile) error {
+		c.Reviews = upsertByID(c.Reviews, review, func(r Review) string { return r.Id })
+		return nil
+	})
 }
 
-// save books to books.json file
-func saveBooks(books []Book) error {
+func (s *JSONStore) DeleteReview(id string) error {
+	return s.mutate(func(c *catalogFile) (err error) {
+		c.Reviews, err = removeByID(c.Reviews, id, func(r Review) string { return r.Id })
+		return err
+	})
+}
+
+// findByID returns the element of list whose id is id, or ErrNotFound.
+func findByID[T any](list []T, id string, idOf func(T) string) (T, error) {
+	for _, v := range list {
+		if idOf(v) == id {
+			return v, nil
+		}
+	}
+	var zero T
+	return zero, ErrNotFound
+}
+
+// upsertByID replaces the element of list with the id of v, or appends v.
+func upsertByID[T any](list []T, v T, idOf func(T) string) []T {
+	for i, existing := range list {
+		if idOf(existing) == idOf(v) {
+			list[i] = v
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// removeByID deletes the element with the given id from list, or returns
+// ErrNotFound.
+func removeByID[T any](list []T, id string, idOf func(T) string) ([]T, error) {
+	i := slices.IndexFunc(list, func(v T) bool { return idOf(v) == id })
+	if i < 0 {
+		return list, ErrNotFound
+	}
+	return slices.Delete(list, i, i+1), nil
+}
+
+func (s *JSONStore) GetCart(id string) (Cart, error) {
+	c, err := s.read()
+	if err != nil {
+		return Cart{}, err
+	}
+	for _, cart := range c.Carts {
+		if cart.Id == id {
+			return cart.clone(), nil
+		}
+	}
+	return Cart{}, ErrNotFound
+}
+
+func (s *JSONStore) SaveCart(cart Cart) error {
+	return s.mutate(func(c *catalogFile) error {
+		for i, existing := range c.Carts {
+			if existing.Id == cart.Id {
+				c.Carts[i] = cart
+				return nil
+			}
+		}
+		c.Carts = append(c.Carts, cart)
+		return nil
+	})
+}
+
+func (s *JSONStore) DeleteCart(id string) error {
+	return s.mutate(func(c *catalogFile) error {
+		return deleteCart(c, id)
+	})
+}
+
+func (s *JSONStore) GetOrder(id string) (Order, error) {
+	c, err := s.read()
+	if err != nil {
+		return Order{}, err
+	}
+	for _, order := range c.Orders {
+		if order.Id == id {
+			return order.clone(), nil
+		}
+	}
+	return Order{}, ErrNotFound
+}
+
+func (s *JSONStore) ListOrders() ([]Order, error) {
+	c, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if c.Orders == nil {
+		return []Order{}, nil
+	}
+	return cloneEach(c.Orders), nil
+}
+
+// PlaceOrder applies the whole checkout in a single write of the file.
+func (s *JSONStore) PlaceOrder(order Order, books []Book, cartId string) error {
+	return s.mutate(func(c *catalogFile) error {
+		for _, book := range books {
+			i := slices.IndexFunc(c.Books, func(b Book) bool { return b.Id == book.Id })
+			if i < 0 {
+				return fmt.Errorf("book %s: %w", book.Id, ErrNotFound)
+			}
+			c.Books[i] = book
+		}
+		c.Orders = append(c.Orders, order)
+		return deleteCart(c, cartId)
+	})
+}
+
+func deleteCart(c *catalogFile, id string) error {
+	for i, cart := range c.Carts {
+		if cart.Id == id {
+			c.Carts = append(c.Carts[:i], c.Carts[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Close waits for an in-progress write to finish.
+func (s *JSONStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nil
+}
+
+// mutate runs change on the current file contents and saves the result
+// unless change fails.
+func (s *JSONStore) mutate(change func(c *catalogFile) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// an outside edit the watcher has not noticed yet must not be lost
+	if stamp, err := statCatalog(s.path); err != nil || !s.cached(stamp) {
+		s.invalidate()
+	}
+	cached, err := s.read()
+	if err != nil {
+		return err
+	}
+	c := cached.clone()
+	if err := change(&c); err != nil {
+		return err
+	}
+	if err := saveCatalog(s.path, c); err != nil {
+		s.invalidate()
+		return err
+	}
+	s.remember(c)
+	return nil
+}
+
+func readCatalog(path string) (catalogFile, error) {
+	c := catalogFile{Books: []Book{}}
+	booksByte, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if trimmed := bytes.TrimSpace(booksByte); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(booksByte, &c.Books)
+	} else {
+		err = json.Unmarshal(booksByte, &c)
+	}
+	if err != nil {
+		return c, err
+	}
+	if c.Books == nil {
+		c.Books = []Book{}
+	}
+	return c, nil
+}
+
+// save the catalog to the JSON file at path. The data is written to a
+// temporary file in the same directory and renamed over path, so a crash
+// mid-write leaves the previous catalog intact.
+func saveCatalog(path string, c catalogFile) error {
 
 	// converting into bytes for writing into a file
-	booksBytes, err := json.Marshal(books)
+	var content any = c
+	if len(c.Authors) == 0 && len(c.Categories) == 0 && len(c.Reviews) == 0 &&
+		len(c.Carts) == 0 && len(c.Orders) == 0 {
+		content = c.Books
+	}
+	booksBytes, err := json.Marshal(content)
 
 	checkError(err)
 
-	err = os.WriteFile("./books.json", booksBytes, 0644)
+	return writeFileAtomic(path, booksBytes)
+
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory
+// and renames it over path, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// no-op once the rename succeeded
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	return err
+// Reasons a submitted book is rejected by AppendNewBooks.
+const (
+	reasonDuplicateID     = "duplicate_id"
+	reasonLikelyDuplicate = "likely_duplicate"
+)
 
+// RejectedBook is a submitted book that was not added to the catalog.
+type RejectedBook struct {
+	Index      int    `json:"index"`  // position in the submitted array
+	Reason     string `json:"reason"` // duplicate_id|likely_duplicate
+	ExistingId string `json:"existing_id"`
+	Book       Book   `json:"book"`
 }
 
-func AppendNewBooks(books, newBooks []Book) []Book {
-	// Build a set of IDs that already exist in `books`.
+// AppendNewBooks appends the books of newBooks that are not already in
+// books and reports the others. A book is a duplicate if its id is taken
+// or, unless allowSimilar is set, if its normalized title and author match
+// an existing book (see similarityKey).
+func AppendNewBooks(books, newBooks []Book, allowSimilar bool) ([]Book, []RejectedBook) {
+	// Build a set of IDs and similarity keys that already exist in `books`.
 	existing := make(map[string]struct{}, len(books))
+	similar := make(map[string]string, len(books))
 	for _, b := range books {
 		existing[b.Id] = struct{}{}
+		if key := similarityKey(b); key != "" {
+			similar[key] = b.Id
+		}
 	}
 
 	// Walk through newBooks and append only the “new” ones.
-	for _, nb := range newBooks {
-		if _, ok := existing[nb.Id]; !ok {
-			books = append(books, nb)
-			existing[nb.Id] = struct{}{}
+	var rejected []RejectedBook
+	for i, nb := range newBooks {
+		key := similarityKey(nb)
+		if _, ok := existing[nb.Id]; ok {
+			rejected = append(rejected, RejectedBook{i, reasonDuplicateID, nb.Id, nb})
+			continue
+		}
+		if id, ok := similar[key]; ok && key != "" && !allowSimilar {
+			rejected = append(rejected, RejectedBook{i, reasonLikelyDuplicate, id, nb})
+			continue
+		}
+		books = append(books, nb)
+		existing[nb.Id] = struct{}{}
+		if key != "" {
+			similar[key] = nb.Id
 		}
 	}
 	// If nothing was added, `books` is exactly the original slice.
-	return books
+	return books, rejected
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Category is a genre or subject books are filed under. A book may belong
+// to several categories, see Book.CategoryIds.
+type Category struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Validate reports every field of c that does not satisfy the Category
+// schema.
+func (c Category) Validate() []FieldError {
+	if strings.TrimSpace(c.Name) == "" {
+		return []FieldError{{"name", "is required"}}
+	}
+	return nil
+}
+
+// checkCategoryName returns errNameTaken if a category other than id
+// already goes by name.
+func checkCategoryName(id, name string) error {
+	categories, err := store.ListCategories()
+	if err != nil {
+		return err
+	}
+	key := normalizeName(name)
+	for _, c := range categories {
+		if c.Id != id && normalizeName(c.Name) == key {
+			return fmt.Errorf("category %q: %w", c.Name, errNameTaken)
+		}
+	}
+	return nil
+}
+
+func handleListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := store.ListCategories()
+	if err != nil {
+		writeRelationError(w, r, err, "Category not found")
+		return
+	}
+	writeJSON(w, 200, categories)
+}
+
+func handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	var category Category
+	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	if category.Id == "" {
+		category.Id = newID()
+	}
+	category.Name = strings.TrimSpace(category.Name)
+	if fieldErrs := category.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	if _, err := store.GetCategory(category.Id); err == nil {
+		writeError(w, 409, "Category already exists")
+		return
+	}
+	err := checkCategoryName(category.Id, category.Name)
+	if err == nil {
+		err = store.SaveCategory(category)
+	}
+	if err != nil {
+		writeRelationError(w, r, err, "Category not found")
+		return
+	}
+	w.Header().Set("Location", "/categories/"+url.PathEscape(category.Id))
+	writeJSON(w, 201, category)
+}
+
+func handleGetCategory(w http.ResponseWriter, r *http.Request) {
+	category, err := store.GetCategory(r.PathValue("id"))
+	if err != nil {
+		writeRelationError(w, r, err, "Category not found")
+		return
+	}
+	writeJSON(w, 200, category)
+}
+
+// handleReplaceCategory overwrites a category. Books refer to it by id
+// only, so they need no update.
+func handleReplaceCategory(w http.ResponseWriter, r *http.Request) {
+	var category Category
+	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	category.Id = r.PathValue("id")
+	category.Name = strings.TrimSpace(category.Name)
+	if fieldErrs := category.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	_, err := store.GetCategory(category.Id)
+	if err == nil {
+		err = checkCategoryName(category.Id, category.Name)
+	}
+	if err == nil {
+		err = store.SaveCategory(category)
+	}
+	if err != nil {
+		writeRelationError(w, r, err, "Category not found")
+		return
+	}
+	writeJSON(w, 200, category)
+}
+
+// handleDeleteCategory removes a category no book is filed under anymore.
+func handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	books, err := referencingBooks(func(b Book) bool { return slices.Contains(b.CategoryIds, id) })
+	if err == nil && len(books) > 0 {
+		err = fmt.Errorf("category %s: %w (%d)", id, errInUse, len(books))
+	}
+	if err == nil {
+		err = store.DeleteCategory(id)
+	}
+	if err != nil {
+		writeRelationError(w, r, err, "Category not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handleListCategoryBooks lists the books filed under a category and
+// accepts the parameters of GET /books.
+func handleListCategoryBooks(w http.ResponseWriter, r *http.Request) {
+	category, err := store.GetCategory(r.PathValue("id"))
+	if err != nil {
+		writeRelationError(w, r, err, "Category not found")
+		return
+	}
+	query, fieldErrs := parseListQuery(r.URL.Query())
+	if fieldErrs != nil {
+		writeError(w, 400, "Invalid query parameters", fieldErrs...)
+		return
+	}
+	query.categoryId = category.Id
+	writeBookList(w, r, query)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runCLI implements the offline subcommands:
+//
+//	books import [-format csv|ndjson|json] [-on-conflict skip|upsert] [file]
+//	books export [-format csv|ndjson|json] [file]
+//
+// They operate directly on the configured store; a file of "-" or none
+// means stdin/stdout. The exit code is returned.
+func runCLI(command string, args []string) int {
+	env := envReader{}
+	fs := flag.NewFlagSet("books "+command, flag.ExitOnError)
+	storeKind := fs.String("store", env.string("BOOKS_STORE", "json"), "Catalog back-end: json or sqlite")
+	dataPath := fs.String("data", env.string("BOOKS_DATA", "./books.json"), "Path to the JSON file or SQLite database")
+	auditPath := fs.String("audit-log", env.string("BOOKS_AUDIT_LOG", ""), "Append-only audit log; defaults to the data path with an .audit.ndjson extension")
+	currency := fs.String("default-currency", env.string("BOOKS_DEFAULT_CURRENCY", "USD"), "ISO 4217 currency of prices given without one")
+	format := fs.String("format", formatJSON, "Transfer format: csv, ndjson or json")
+	onConflict := fs.String("on-conflict", onConflictSkip, "What to do with existing ids on import: skip or upsert")
+	fs.Parse(args)
+
+	if _, ok := formatContentTypes[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "unsupported format %q\n", *format)
+		return 2
+	}
+	if *onConflict != onConflictSkip && *onConflict != onConflictUpsert {
+		fmt.Fprintf(os.Stderr, "-on-conflict must be skip or upsert\n")
+		return 2
+	}
+	if !currencyRe.MatchString(*currency) {
+		fmt.Fprintf(os.Stderr, "-default-currency must be an ISO 4217 code\n")
+		return 2
+	}
+	defaultCurrency = *currency
+
+	backend, err := openStore(*storeKind, *dataPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	store = backend
+	defer store.Close()
+
+	if audit, err = openAuditLog(defaultAuditPath(*auditPath, *dataPath)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	path := fs.Arg(0)
+	switch command {
+	case "import":
+		var in io.Reader = os.Stdin
+		if path != "" && path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			in = f
+		}
+		records, err := decodeBooks(in, *format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		report, err := importBooks("cli", records, *onConflict)
+		for _, row := range report.Rows {
+			fmt.Printf("row %d\t%s\t%s", row.Row, row.Id, row.Status)
+			for _, fe := range row.Errors {
+				fmt.Printf("\t%s %s", fe.Field, fe.Msg)
+			}
+			fmt.Println()
+		}
+		fmt.Printf("created %d, updated %d, skipped %d, invalid %d\n",
+			report.Created, report.Updated, report.Skipped, report.Invalid)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if report.Invalid > 0 {
+			return 3
+		}
+		return 0
+
+	case "export":
+		var out io.Writer = os.Stdout
+		if path != "" && path != "-" {
+			f, err := os.Create(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			out = f
+		}
+		books, err := getBooks()
+		if err == nil {
+			err = encodeBooks(out, books, *format)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+	return 2
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// Cart is an open shopping cart. Prices are not stored; they are taken
+// from the catalog whenever the cart is shown or checked out.
+type Cart struct {
+	Id        string     `json:"id"`
+	Items     []CartItem `json:"items"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type CartItem struct {
+	BookId   string `json:"book_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// OrderLine is a priced cart item.
+type OrderLine struct {
+	BookId    string `json:"book_id"`
+	Title     string `json:"title"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice string `json:"unit_price"`
+	LineTotal string `json:"line_total"`
+}
+
+// Order is a checked out cart. Orders are immutable: lines and totals keep
+// the prices at the time of checkout.
+type Order struct {
+	Id        string      `json:"id"`
+	CartId    string      `json:"cart_id"`
+	Lines     []OrderLine `json:"lines"`
+	Total     string      `json:"total"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// CartView is a cart priced with the current catalog prices.
+type CartView struct {
+	Cart
+	Lines []OrderLine `json:"lines"`
+	Total string      `json:"total"`
+}
+
+// errUnpriced is returned for carts holding a book without a price.
+var errUnpriced = errors.New("book has no price")
+
+// errTotalTooLarge is returned for carts whose total does not fit in an
+// int64 number of cents.
+var errTotalTooLarge = errors.New("total is too large")
+
+// maxCartQuantity is the most copies of one book a cart may hold.
+const maxCartQuantity = 1000
+
+// priceCart prices every item of cart and returns the lines, the total and
+// the books they refer to.
+func priceCart(cart Cart) ([]OrderLine, Money, []Book, error) {
+	lines := make([]OrderLine, 0, len(cart.Items))
+	books := make([]Book, 0, len(cart.Items))
+	var total Money
+	for i, item := range cart.Items {
+		book, err := getLiveBook(item.BookId)
+		if err != nil {
+			return nil, Money{}, nil, fmt.Errorf("book %s: %w", item.BookId, err)
+		}
+		if book.Price.IsZero() {
+			return nil, Money{}, nil, fmt.Errorf("book %s: %w", book.Id, errUnpriced)
+		}
+		unit := book.Price
+		if unit.Amount > math.MaxInt64/maxCartQuantity {
+			return nil, Money{}, nil, fmt.Errorf("book %s: %w", book.Id, errTotalTooLarge)
+		}
+		lineTotal := unit.Times(item.Quantity)
+		if i == 0 {
+			total = lineTotal
+		} else if total, err = total.Plus(lineTotal); err != nil {
+			return nil, Money{}, nil, err
+		} else if total.Amount < lineTotal.Amount {
+			return nil, Money{}, nil, errTotalTooLarge
+		}
+		lines = append(lines, OrderLine{book.Id, book.Title, item.Quantity, unit.String(), lineTotal.String()})
+		books = append(books, book)
+	}
+	return lines, total, books, nil
+}
+
+func viewCart(cart Cart) (CartView, error) {
+	lines, total, _, err := priceCart(cart)
+	if err != nil {
+		return CartView{}, err
+	}
+	return CartView{cart, lines, total.String()}, nil
+}
+
+// writeCartView prices cart and writes it with the given status.
+func writeCartView(w http.ResponseWriter, r *http.Request, status int, cart Cart) {
+	view, err := viewCart(cart)
+	if err != nil {
+		writeCommerceError(w, r, err, "Book in cart not found")
+		return
+	}
+	writeJSON(w, status, view)
+}
+
+// writeCommerceError maps cart and order errors to a JSON response.
+func writeCommerceError(w http.ResponseWriter, r *http.Request, err error, notFound string) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, 404, notFound)
+	case errors.Is(err, ErrInsufficientStock), errors.Is(err, errUnpriced), errors.Is(err, errMixedCurrencies), errors.Is(err, errTotalTooLarge):
+		writeError(w, 409, err.Error())
+	default:
+		logError(r, err)
+		writeError(w, 500, "Internal server error")
+	}
+}
+
+func handleCreateCart(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	cart := Cart{Id: newID(), Items: []CartItem{}, CreatedAt: now, UpdatedAt: now}
+	if err := store.SaveCart(cart); err != nil {
+		writeCommerceError(w, r, err, "Cart not found")
+		return
+	}
+	w.Header().Set("Location", "/carts/"+url.PathEscape(cart.Id))
+	writeCartView(w, r, 201, cart)
+}
+
+func handleGetCart(w http.ResponseWriter, r *http.Request) {
+	cart, err := store.GetCart(r.PathValue("id"))
+	if err != nil {
+		writeCommerceError(w, r, err, "Cart not found")
+		return
+	}
+	writeCartView(w, r, 200, cart)
+}
+
+func handleDeleteCart(w http.ResponseWriter, r *http.Request) {
+	if err := store.DeleteCart(r.PathValue("id")); err != nil {
+		writeCommerceError(w, r, err, "Cart not found")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handleSetCartItem sets the quantity of a book in a cart; a quantity of
+// 0 removes it. Quantities above the available stock are refused, though
+// the stock is only taken at checkout.
+func handleSetCartItem(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Quantity int `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	if req.Quantity < 0 || req.Quantity > maxCartQuantity {
+		writeError(w, 400, "Validation failed", FieldError{"quantity", fmt.Sprintf("must be between 0 and %d", maxCartQuantity)})
+		return
+	}
+	bookId := r.PathValue("bookId")
+	book, err := getLiveBook(bookId)
+	if err != nil {
+		writeCommerceError(w, r, err, "Book Not found")
+		return
+	}
+	if available := book.Inventory.Available(); req.Quantity > available {
+		writeError(w, 409, "Insufficient stock", FieldError{"quantity", fmt.Sprintf("only %d available", available)})
+		return
+	}
+	updateCart(w, r, r.PathValue("id"), bookId, req.Quantity)
+}
+
+func handleRemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	updateCart(w, r, r.PathValue("id"), r.PathValue("bookId"), 0)
+}
+
+func updateCart(w http.ResponseWriter, r *http.Request, cartId, bookId string, qty int) {
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	cart, err := store.GetCart(cartId)
+	if err != nil {
+		writeCommerceError(w, r, err, "Cart not found")
+		return
+	}
+	i := slices.IndexFunc(cart.Items, func(item CartItem) bool { return item.BookId == bookId })
+	switch {
+	case i < 0 && qty > 0:
+		cart.Items = append(cart.Items, CartItem{bookId, qty})
+	case i >= 0 && qty > 0:
+		cart.Items[i].Quantity = qty
+	case i >= 0:
+		cart.Items = slices.Delete(cart.Items, i, i+1)
+	}
+	cart.UpdatedAt = time.Now().UTC()
+	if err := store.SaveCart(cart); err != nil {
+		writeCommerceError(w, r, err, "Cart not found")
+		return
+	}
+	writeCartView(w, r, 200, cart)
+}
+
+// handleCheckout turns a cart into an order: it prices the items, takes
+// them out of stock and stores the order in one step, then drops the cart.
+func handleCheckout(w http.ResponseWriter, r *http.Request) {
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	cart, err := store.GetCart(r.PathValue("id"))
+	if err != nil {
+		writeCommerceError(w, r, err, "Cart not found")
+		return
+	}
+	if len(cart.Items) == 0 {
+		writeError(w, 409, "Cart is empty")
+		return
+	}
+	lines, total, books, err := priceCart(cart)
+	if err != nil {
+		writeCommerceError(w, r, err, "Book in cart not found")
+		return
+	}
+	for i, item := range cart.Items {
+		if err := books[i].Inventory.Adjust(-item.Quantity); err != nil {
+			writeCommerceError(w, r, fmt.Errorf("book %s: %w", item.BookId, err), "")
+			return
+		}
+	}
+
+	order := Order{
+		Id:        newID(),
+		CartId:    cart.Id,
+		Lines:     lines,
+		Total:     total.String(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := storeAs(actorFromContext(r.Context())).PlaceOrder(order, books, cart.Id); err != nil {
+		writeCommerceError(w, r, err, "Cart not found")
+		return
+	}
+	w.Header().Set("Location", "/orders/"+url.PathEscape(order.Id))
+	writeJSON(w, 201, order)
+}
+
+func handleListOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := store.ListOrders()
+	if err != nil {
+		writeCommerceError(w, r, err, "")
+		return
+	}
+	writeJSON(w, 200, orders)
+}
+
+func handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := store.GetOrder(r.PathValue("id"))
+	if err != nil {
+		writeCommerceError(w, r, err, "Order not found")
+		return
+	}
+	writeJSON(w, 200, order)
+}
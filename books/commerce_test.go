@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// newCart stocks two books and returns the id of a new, empty cart.
+func newCart(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	post(t, srv, "/books", `{"id":"b","title":"Emma","author":"Jane Austen","price":"4.50"}`, 201)
+	post(t, srv, "/books/a/inventory/adjust", `{"delta":5}`, 200)
+	post(t, srv, "/books/b/inventory/adjust", `{"delta":1}`, 200)
+	var cart CartView
+	if err := json.Unmarshal(post(t, srv, "/carts", "", 201), &cart); err != nil {
+		t.Fatal(err)
+	}
+	return cart.Id
+}
+
+func TestSetCartItemLimits(t *testing.T) {
+	srv, _ := newTestServer(t)
+	cart := newCart(t, srv)
+
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"quantity":-1}`, 400},
+		{`{"quantity":1001}`, 400},
+		{`{"quantity":9223372036854775807}`, 400},
+		{`{"quantity":6}`, 409}, // 5 in stock
+		{`{"quantity":5}`, 200},
+	} {
+		send(t, srv, "PUT", "/carts/"+cart+"/items/a", tt.body, tt.want)
+	}
+	post(t, srv, "/books/a/inventory/reserve", `{"quantity":2}`, 200)
+	send(t, srv, "PUT", "/carts/"+cart+"/items/a", `{"quantity":4}`, 409)
+	send(t, srv, "PUT", "/carts/"+cart+"/items/a", `{"quantity":3}`, 200)
+	send(t, srv, "PUT", "/carts/"+cart+"/items/missing", `{"quantity":1}`, 404)
+}
+
+func TestCheckout(t *testing.T) {
+	srv, _ := newTestServer(t)
+	cart := newCart(t, srv)
+	send(t, srv, "PUT", "/carts/"+cart+"/items/a", `{"quantity":2}`, 200)
+	send(t, srv, "PUT", "/carts/"+cart+"/items/b", `{"quantity":1}`, 200)
+
+	// the stock of b is sold elsewhere before checkout
+	post(t, srv, "/books/b/inventory/adjust", `{"delta":-1}`, 200)
+	post(t, srv, "/carts/"+cart+"/checkout", "", 409)
+	if a, _ := store.Get("a"); a.Inventory.OnHand != 5 {
+		t.Errorf("a: %d on hand after a failed checkout, want 5", a.Inventory.OnHand)
+	}
+	if orders, _ := store.ListOrders(); len(orders) != 0 {
+		t.Errorf("orders after a failed checkout: %+v", orders)
+	}
+
+	post(t, srv, "/books/b/inventory/adjust", `{"delta":1}`, 200)
+	var order Order
+	if err := json.Unmarshal(post(t, srv, "/carts/"+cart+"/checkout", "", 201), &order); err != nil {
+		t.Fatal(err)
+	}
+	if order.Total != "24.48 USD" || len(order.Lines) != 2 || order.Lines[0].LineTotal != "19.98 USD" || order.CartId != cart {
+		t.Errorf("order = %+v, want 2 x 9.99 + 4.50 USD", order)
+	}
+	for id, want := range map[string]int{"a": 3, "b": 0} {
+		if book, _ := store.Get(id); book.Inventory.OnHand != want {
+			t.Errorf("%s: %d on hand, want %d", id, book.Inventory.OnHand, want)
+		}
+	}
+
+	var stored Order
+	if err := json.Unmarshal(send(t, srv, "GET", "/orders/"+order.Id, "", 200), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Id != order.Id || stored.Total != order.Total {
+		t.Errorf("stored order = %+v, want %+v", stored, order)
+	}
+	// the cart is gone
+	send(t, srv, "GET", "/carts/"+cart, "", 404)
+	post(t, srv, "/carts/"+cart+"/checkout", "", 404)
+}
+
+func TestPriceCartRejectsOverflow(t *testing.T) {
+	newTestServer(t)
+	for _, b := range []Book{
+		{Id: "a", Title: "Dune", Author: "Frank Herbert", Price: Money{Amount: 1 << 62, Currency: "USD"}},
+		{Id: "b", Title: "Emma", Author: "Jane Austen", Price: Money{Amount: 1 << 52, Currency: "USD"}},
+	} {
+		if err := store.Create(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, items := range [][]CartItem{
+		{{"a", 1}},
+		{{"b", 1000}, {"b", 1000}, {"b", 1000}},
+	} {
+		if _, _, _, err := priceCart(Cart{Items: items}); err == nil {
+			t.Errorf("priceCart(%v) did not overflow", items)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mutateMu serializes read-check-write cycles of the handlers that honor
+// If-Match, so the precondition still holds when the write happens.
+var mutateMu sync.Mutex
+
+// versionedStore wraps a BookStore and records when the catalog last
+// changed. The clock starts at process start, so clients revalidate after
+// a restart.
+type versionedStore struct {
+	BookStore
+	modified atomic.Int64 // unix nanoseconds
+}
+
+func newVersionedStore(s BookStore) *versionedStore {
+	vs := &versionedStore{BookStore: s}
+	vs.touch()
+	return vs
+}
+
+func (s *versionedStore) Create(book Book) error {
+	return s.touchOnSuccess(s.BookStore.Create(book))
+}
+
+func (s *versionedStore) Update(book Book) error {
+	return s.touchOnSuccess(s.BookStore.Update(book))
+}
+
+func (s *versionedStore) Delete(id string) error {
+	return s.touchOnSuccess(s.BookStore.Delete(id))
+}
+
+func (s *versionedStore) SaveBatch(authors []Author, create, update []Book) error {
+	return s.touchOnSuccess(s.BookStore.SaveBatch(authors, create, update))
+}
+
+func (s *versionedStore) PlaceOrder(order Order, books []Book, cartId string) error {
+	return s.touchOnSuccess(s.BookStore.PlaceOrder(order, books, cartId))
+}
+
+// LastModified returns the time of the last successful mutation.
+func (s *versionedStore) LastModified() time.Time {
+	return time.Unix(0, s.modified.Load())
+}
+
+func (s *versionedStore) touch() {
+	s.modified.Store(time.Now().UnixNano())
+}
+
+func (s *versionedStore) touchOnSuccess(err error) error {
+	if err == nil {
+		s.touch()
+	}
+	return err
+}
+
+// catalogModTime returns when the catalog last changed, or the zero time
+// if the store does not track it.
+func catalogModTime() time.Time {
+	if vs, ok := store.(interface{ LastModified() time.Time }); ok {
+		return vs.LastModified()
+	}
+	return time.Time{}
+}
+
+// etagOf returns a strong entity tag for the JSON encoding of v.
+func etagOf(v any) string {
+	body, _ := json.Marshal(v)
+	return etagOfBytes(body)
+}
+
+func etagOfBytes(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the validators on w and reports whether the request's
+// If-None-Match / If-Modified-Since headers allow a 304 response, in which
+// case it has already been written.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.2.2)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag, true) {
+			return false
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil ||
+		modTime.IsZero() || modTime.Truncate(time.Second).After(ims) {
+		return false
+	}
+	w.WriteHeader(304)
+	return true
+}
+
+// preconditionFailed checks the request's If-Match header against the
+// current version of book and writes a 412 response if it does not match.
+func preconditionFailed(w http.ResponseWriter, r *http.Request, book Book) bool {
+	im := r.Header.Get("If-Match")
+	if im == "" || etagMatches(im, etagOf(book), false) {
+		return false
+	}
+	w.Header().Set("ETag", etagOf(book))
+	writeError(w, 412, "Book was modified by another request")
+	return true
+}
+
+// etagMatches reports whether a comma separated If-Match / If-None-Match
+// header value contains etag or "*". With weak set, weak tags compare by
+// their opaque part as If-None-Match requires; otherwise they never match,
+// as If-Match requires (RFC 9110, section 13.1.1).
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"abc"`, false, true},
+		{`"abc"`, true, true},
+		{`"x", "abc"`, false, true},
+		{`*`, false, true},
+		{`"x"`, true, false},
+		// If-None-Match compares weakly, If-Match strongly
+		{`W/"abc"`, true, true},
+		{`W/"abc"`, false, false},
+		{`"x", W/"abc"`, false, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag, tt.weak); got != tt.want {
+			t.Errorf("etagMatches(%s, weak=%v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+}
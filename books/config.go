@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every configurable value of the server.
+type Config struct {
+	// Server
+	Addr            string        // listen address, e.g. ":8080"
+	ReadTimeout     time.Duration // whole request, including the body
+	WriteTimeout    time.Duration // from end of request headers to end of response
+	IdleTimeout     time.Duration // keep-alive connections
+	ShutdownTimeout time.Duration // how long to drain in-flight requests
+	LogLevel        string        // debug|info|warn|error
+	TLSCert         string        // PEM certificate; serves HTTPS when set
+	TLSKey          string        // PEM private key matching TLSCert
+
+	// Persistence
+	StoreKind     string        // json|sqlite
+	DataPath      string        // path to the JSON file or SQLite database
+	AuditPath     string        // append-only NDJSON log of book changes
+	WatchInterval time.Duration // how often the JSON file is checked for outside edits
+
+	// Pricing
+	DefaultCurrency   string // assumed for prices stored without a currency
+	ExchangeRatesPath string // JSON exchange-rate table used for ?currency= conversions
+
+	// Trash
+	TrashRetention time.Duration // how long deleted books are kept; 0 keeps them forever
+	PurgeInterval  time.Duration // how often expired books are purged
+
+	// Cover images
+	CoverDir      string // directory for uploaded covers and thumbnails
+	MaxCoverBytes int64  // largest accepted cover upload
+	PublicURL     string // external base URL used in image_url, e.g. "https://books.example.com"
+
+	// Limits
+	RateLimit    float64 // requests per second per client; 0 disables rate limiting
+	RateBurst    int     // requests a client may send at once
+	MaxBodyBytes int64   // largest accepted request body, except cover uploads
+	MaxBulkBooks int     // most books per /add or /books/import request; 0 means no limit
+
+	// Webhooks
+	WebhookLogPath  string        // NDJSON file of the webhooks and their deliveries
+	WebhookTimeout  time.Duration // per delivery attempt
+	WebhookAttempts int           // attempts before a delivery fails
+	WebhookBackoff  time.Duration // delay before the first retry, doubled after each failure
+
+	// Live updates
+	EventBuffer  int           // recent events replayed to clients resuming with Last-Event-ID
+	SSEKeepalive time.Duration // how often idle event streams send a comment
+
+	// Authentication
+	APIKeys     string // comma separated [name=]key[:read|:write]
+	TokenSecret string // HMAC secret for bearer tokens
+	PublicReads bool   // serve GET routes without credentials
+	NoAuth      bool   // allow starting without credentials; every caller may write
+	Moderators  string // comma separated subjects allowed to moderate reviews; empty allows every writer
+
+	// -mint-token mode
+	MintToken string
+	TokenTTL  time.Duration
+}
+
+// loadConfig reads configuration from (in decreasing priority):
+//  1. command-line flags (e.g. -addr :9090)
+//  2. environment variables (e.g. BOOKS_ADDR=:9090)
+//  3. built-in defaults.
+func loadConfig(args []string) (*Config, error) {
+	env := envReader{}
+	cfg := &Config{}
+
+	fs := flag.NewFlagSet("books", flag.ExitOnError)
+	fs.StringVar(&cfg.Addr, "addr", env.string("BOOKS_ADDR", ":8080"), "Listen address")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", env.duration("BOOKS_READ_TIMEOUT", 10*time.Second), "Maximum duration for reading a request")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", env.duration("BOOKS_WRITE_TIMEOUT", 30*time.Second), "Maximum duration before timing out writes of a response")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", env.duration("BOOKS_IDLE_TIMEOUT", 2*time.Minute), "Maximum time to wait for the next request on a keep-alive connection")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", env.duration("BOOKS_SHUTDOWN_TIMEOUT", 15*time.Second), "Maximum time to drain in-flight requests on SIGINT/SIGTERM")
+	fs.StringVar(&cfg.LogLevel, "log-level", env.string("BOOKS_LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", env.string("BOOKS_TLS_CERT", ""), "TLS certificate file; enables HTTPS together with -tls-key")
+	fs.StringVar(&cfg.TLSKey, "tls-key", env.string("BOOKS_TLS_KEY", ""), "TLS private key file")
+	fs.StringVar(&cfg.StoreKind, "store", env.string("BOOKS_STORE", "json"), "Catalog back-end: json or sqlite")
+	fs.StringVar(&cfg.DataPath, "data", env.string("BOOKS_DATA", "./books.json"), "Path to the JSON file or SQLite database")
+	fs.StringVar(&cfg.AuditPath, "audit-log", env.string("BOOKS_AUDIT_LOG", ""), "Append-only audit log; defaults to the data path with an .audit.ndjson extension")
+	fs.DurationVar(&cfg.WatchInterval, "watch-interval", env.duration("BOOKS_WATCH_INTERVAL", time.Second), "How often the JSON store checks its file for changes made by other programs")
+	fs.StringVar(&cfg.DefaultCurrency, "default-currency", env.string("BOOKS_DEFAULT_CURRENCY", "USD"), "ISO 4217 currency of prices stored without one")
+	fs.StringVar(&cfg.ExchangeRatesPath, "exchange-rates", env.string("BOOKS_EXCHANGE_RATES", ""), `JSON exchange-rate table, e.g. {"base":"USD","rates":{"EUR":0.92}}`)
+	fs.DurationVar(&cfg.TrashRetention, "trash-retention", env.duration("BOOKS_TRASH_RETENTION", 30*24*time.Hour), "How long deleted books stay in the trash; 0 keeps them forever")
+	fs.DurationVar(&cfg.PurgeInterval, "purge-interval", env.duration("BOOKS_PURGE_INTERVAL", time.Hour), "How often books past the trash retention are purged")
+	fs.StringVar(&cfg.CoverDir, "cover-dir", env.string("BOOKS_COVER_DIR", "./covers"), "Directory for uploaded cover images")
+	fs.Int64Var(&cfg.MaxCoverBytes, "max-cover-bytes", env.int64("BOOKS_MAX_COVER_BYTES", 5<<20), "Largest accepted cover upload in bytes")
+	fs.StringVar(&cfg.PublicURL, "public-url", env.string("BOOKS_PUBLIC_URL", ""), "External base URL of the server; defaults to the request's host")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", env.float64("BOOKS_RATE_LIMIT", 10), "Requests per second allowed per client IP; 0 disables rate limiting")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", int(env.int64("BOOKS_RATE_BURST", 20)), "Requests a client may send in a burst before being limited")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", env.int64("BOOKS_MAX_BODY_BYTES", 1<<20), "Largest accepted request body in bytes (cover uploads use -max-cover-bytes)")
+	fs.IntVar(&cfg.MaxBulkBooks, "max-bulk-books", int(env.int64("BOOKS_MAX_BULK_BOOKS", 1000)), "Most books accepted by one /add or /books/import request; 0 means no limit")
+	fs.StringVar(&cfg.WebhookLogPath, "webhook-log", env.string("BOOKS_WEBHOOK_LOG", ""), "File of the webhooks and their deliveries; defaults to the data path with a .webhooks.ndjson extension")
+	fs.DurationVar(&cfg.WebhookTimeout, "webhook-timeout", env.duration("BOOKS_WEBHOOK_TIMEOUT", 10*time.Second), "Maximum duration of one webhook delivery attempt")
+	fs.IntVar(&cfg.WebhookAttempts, "webhook-attempts", int(env.int64("BOOKS_WEBHOOK_ATTEMPTS", 6)), "Attempts before a webhook delivery is given up, at most 50")
+	fs.DurationVar(&cfg.WebhookBackoff, "webhook-backoff", env.duration("BOOKS_WEBHOOK_BACKOFF", 10*time.Second), "Delay before the first webhook retry; doubled after every failure up to 24h")
+	fs.IntVar(&cfg.EventBuffer, "event-buffer", int(env.int64("BOOKS_EVENT_BUFFER", 1000)), "Recent catalog events kept for /books/events clients resuming with Last-Event-ID")
+	fs.DurationVar(&cfg.SSEKeepalive, "sse-keepalive", env.duration("BOOKS_SSE_KEEPALIVE", 15*time.Second), "How often idle /books/events streams send a keepalive comment")
+	fs.StringVar(&cfg.APIKeys, "api-keys", env.string("BOOKS_API_KEYS", ""), "Comma separated API keys, each [name=]key[:read|:write]")
+	fs.StringVar(&cfg.TokenSecret, "token-secret", env.string("BOOKS_TOKEN_SECRET", ""), "HMAC secret used to sign and verify bearer tokens")
+	fs.BoolVar(&cfg.PublicReads, "public-reads", env.bool("BOOKS_PUBLIC_READS", true), "Serve GET routes without credentials")
+	fs.BoolVar(&cfg.NoAuth, "insecure-no-auth", env.bool("BOOKS_INSECURE_NO_AUTH", false), "Start without API keys or token secret; every caller may change the catalog")
+	fs.StringVar(&cfg.Moderators, "moderators", env.string("BOOKS_MODERATORS", ""), "Comma separated subjects allowed to hide reviews; empty allows every caller with the write scope")
+	fs.StringVar(&cfg.MintToken, "mint-token", "", "Print a bearer token for this subject (as subject[:read|:write]) and exit")
+	fs.DurationVar(&cfg.TokenTTL, "token-ttl", 30*24*time.Hour, "Lifetime of tokens printed by -mint-token")
+
+	if env.err != nil {
+		return nil, env.err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Basic validation
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, errors.New("-tls-cert and -tls-key must be set together")
+	}
+	if cfg.DataPath == "" {
+		return nil, errors.New("-data must not be empty")
+	}
+	cfg.AuditPath = defaultAuditPath(cfg.AuditPath, cfg.DataPath)
+	cfg.WebhookLogPath = defaultWebhookLogPath(cfg.WebhookLogPath, cfg.DataPath)
+	if cfg.WatchInterval <= 0 {
+		return nil, errors.New("-watch-interval must be positive")
+	}
+	if !currencyRe.MatchString(cfg.DefaultCurrency) {
+		return nil, errors.New("-default-currency must be an ISO 4217 code, e.g. USD")
+	}
+	if cfg.TrashRetention < 0 || cfg.PurgeInterval <= 0 {
+		return nil, errors.New("-trash-retention must not be negative and -purge-interval must be positive")
+	}
+	if cfg.MaxCoverBytes <= 0 {
+		return nil, errors.New("-max-cover-bytes must be positive")
+	}
+	if cfg.RateLimit < 0 || cfg.RateBurst < 1 {
+		return nil, errors.New("-rate-limit must not be negative and -rate-burst must be at least 1")
+	}
+	if cfg.MaxBodyBytes <= 0 || cfg.MaxBulkBooks < 0 {
+		return nil, errors.New("-max-body-bytes must be positive and -max-bulk-books must not be negative")
+	}
+	if cfg.WebhookTimeout <= 0 || cfg.WebhookBackoff <= 0 {
+		return nil, errors.New("-webhook-timeout and -webhook-backoff must be positive")
+	}
+	if cfg.WebhookAttempts < 1 || cfg.WebhookAttempts > maxWebhookAttempts {
+		return nil, fmt.Errorf("-webhook-attempts must be between 1 and %d", maxWebhookAttempts)
+	}
+	if cfg.APIKeys == "" && cfg.TokenSecret == "" && cfg.MintToken == "" && !cfg.NoAuth {
+		return nil, errNoCredentials
+	}
+	if cfg.EventBuffer < 1 || cfg.SSEKeepalive <= 0 {
+		return nil, errors.New("-event-buffer must be at least 1 and -sse-keepalive must be positive")
+	}
+	return cfg, nil
+}
+
+// errNoCredentials refuses to start a server anybody could write to.
+var errNoCredentials = errors.New("no -api-keys or -token-secret configured; set -insecure-no-auth to run without authentication")
+
+// defaultAuditPath returns auditPath, or if it is empty the data path with
+// its extension replaced, e.g. "./books.json" -> "./books.audit.ndjson".
+func defaultAuditPath(auditPath, dataPath string) string {
+	if auditPath != "" {
+		return auditPath
+	}
+	return strings.TrimSuffix(dataPath, filepath.Ext(dataPath)) + ".audit.ndjson"
+}
+
+// defaultWebhookLogPath is defaultAuditPath for the webhook log, e.g.
+// "./books.json" -> "./books.webhooks.ndjson".
+func defaultWebhookLogPath(webhookLogPath, dataPath string) string {
+	if webhookLogPath != "" {
+		return webhookLogPath
+	}
+	return strings.TrimSuffix(dataPath, filepath.Ext(dataPath)) + ".webhooks.ndjson"
+}
+
+// envReader looks up environment variables and keeps the first parse
+// error so loadConfig can report it once.
+type envReader struct {
+	err error
+}
+
+func (e *envReader) string(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("%s: %w", key, err)
+	}
+	return d
+}
+
+func (e *envReader) int64(key string, def int64) int64 {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("%s: %w", key, err)
+	}
+	return n
+}
+
+func (e *envReader) float64(key string, def float64) float64 {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("%s: %w", key, err)
+	}
+	return f
+}
+
+func (e *envReader) bool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("%s: %w", key, err)
+	}
+	return b
+}
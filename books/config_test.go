@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig([]string{"-insecure-no-auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":8080" || cfg.StoreKind != "json" || cfg.ShutdownTimeout != 15*time.Second || cfg.DefaultCurrency != "USD" {
+		t.Errorf("defaults = %+v", cfg)
+	}
+	if cfg.AuditPath != "./books.audit.ndjson" || cfg.WebhookLogPath != "./books.webhooks.ndjson" {
+		t.Errorf("audit log %q, webhook log %q, want them next to ./books.json", cfg.AuditPath, cfg.WebhookLogPath)
+	}
+}
+
+func TestLoadConfigPriority(t *testing.T) {
+	t.Setenv("BOOKS_ADDR", ":9000")
+	t.Setenv("BOOKS_READ_TIMEOUT", "3s")
+	t.Setenv("BOOKS_DATA", "/srv/catalog.db")
+	t.Setenv("BOOKS_PUBLIC_READS", "false")
+	t.Setenv("BOOKS_API_KEYS", "k")
+
+	cfg, err := loadConfig([]string{"-addr", ":9090"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flags beat the environment, which beats the defaults
+	if cfg.Addr != ":9090" || cfg.ReadTimeout != 3*time.Second || cfg.PublicReads || cfg.APIKeys != "k" {
+		t.Errorf("config = %+v", cfg)
+	}
+	if cfg.AuditPath != "/srv/catalog.audit.ndjson" {
+		t.Errorf("audit log %q, want it next to the data", cfg.AuditPath)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		env, value string
+		args       []string
+		want       string
+	}{
+		{"BOOKS_READ_TIMEOUT", "soon", nil, "BOOKS_READ_TIMEOUT"},
+		{"BOOKS_RATE_BURST", "many", nil, "BOOKS_RATE_BURST"},
+		{"BOOKS_PUBLIC_READS", "maybe", nil, "BOOKS_PUBLIC_READS"},
+		{"", "", []string{"-tls-cert", "cert.pem"}, "-tls-cert and -tls-key"},
+		{"", "", []string{"-data", ""}, "-data"},
+		{"", "", []string{"-default-currency", "dollars"}, "-default-currency"},
+		{"", "", []string{"-purge-interval", "0s"}, "-purge-interval"},
+		{"", "", []string{"-rate-burst", "0"}, "-rate-burst"},
+		{"", "", []string{"-max-body-bytes", "0"}, "-max-body-bytes"},
+		{"", "", []string{"-webhook-attempts", "0"}, "-webhook-attempts"},
+		{"", "", []string{"-webhook-attempts", "51"}, "-webhook-attempts"},
+		{"", "", []string{"-event-buffer", "0"}, "-event-buffer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv(tt.env, tt.value)
+			}
+			_, err := loadConfig(append([]string{"-insecure-no-auth"}, tt.args...))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want one about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeDrainsRequestsOnShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, &Config{ShutdownTimeout: 5 * time.Second}) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		response <- result{string(body), err}
+	}()
+	<-started
+	cancel()
+
+	// new connections are refused while the request in flight finishes
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("still accepting connections after shutdown")
+		}
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned %v with a request in flight", err)
+	default:
+	}
+
+	close(release)
+	if res := <-response; res.err != nil || res.body != "done" {
+		t.Errorf("in-flight request: %q, %v", res.body, res.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve = %v, want nil", err)
+	}
+}
+
+func TestServeGivesUpAfterShutdownTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, &Config{ShutdownTimeout: 50 * time.Millisecond}) }()
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+	cancel()
+
+	if err := <-served; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("serve = %v, want the shutdown timeout", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register the GIF decoder for image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// coverStorage keeps uploaded cover images and their thumbnails on disk.
+type coverStorage struct {
+	dir       string // directory the files are written to
+	maxBytes  int64  // largest accepted upload
+	publicURL string // base URL for image_url; derived from the request if empty
+}
+
+// covers is configured in main from Config.
+var covers = coverStorage{dir: "./covers", maxBytes: 5 << 20}
+
+// uploadLimit is the largest accepted request body for a cover upload,
+// leaving some room for the multipart framing around the image.
+func (c coverStorage) uploadLimit() int64 {
+	return c.maxBytes + 64<<10
+}
+
+// thumbnailSizes are the bounding boxes (in pixels) of the generated
+// thumbnails, keyed by the suffix of their file name.
+var thumbnailSizes = []struct {
+	name string
+	size int
+}{{"small", 150}, {"medium", 400}}
+
+// maxCoverPixels guards against decompression bombs: a small file that
+// decodes to a huge bitmap.
+const maxCoverPixels = 40_000_000
+
+// coverExtensions maps the accepted content types to file extensions.
+var coverExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// coverNameRe matches the files written by saveCover, so handleGetCover
+// can never serve anything else from the directory.
+var coverNameRe = regexp.MustCompile(`^[0-9a-f]{32}(_small|_medium)?\.(jpg|png|gif)$`)
+
+// Thumbnails holds the URLs of the generated thumbnails of an uploaded
+// cover. It is cleared when image_url is changed to another image.
+type Thumbnails struct {
+	Small  string `json:"small,omitempty"`
+	Medium string `json:"medium,omitempty"`
+}
+
+var (
+	errCoverTooLarge = errors.New("cover image is too large")
+	errCoverType     = errors.New("cover must be a JPEG, PNG or GIF image")
+)
+
+// handleUploadCover stores the "cover" part of a multipart upload, e.g.
+//
+//	curl -F cover=@cover.jpg localhost:8080/books/1/cover
+//
+// and points the book's image_url and thumbnails at the stored files.
+func handleUploadCover(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := getLiveBook(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	data, err := readCoverPart(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errCoverTooLarge):
+			writeError(w, 413, fmt.Sprintf("Cover image must not exceed %d bytes", covers.maxBytes))
+		case errors.Is(err, errCoverType):
+			writeError(w, 415, err.Error())
+		default:
+			writeError(w, 400, "Bad Request: "+err.Error())
+		}
+		return
+	}
+
+	name, thumbs, err := covers.save(data)
+	if err != nil {
+		if errors.Is(err, errCoverType) {
+			writeError(w, 415, err.Error())
+			return
+		}
+		logError(r, err)
+		writeError(w, 500, "Internal server error")
+		return
+	}
+
+	base := covers.baseURL(r)
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+	book, err := getLiveBook(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	book.Imageurl = base + name
+	book.Thumbnails = Thumbnails{Small: base + thumbs["small"], Medium: base + thumbs["medium"]}
+	if err := storeAs(actorFromContext(r.Context())).Update(book); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(book))
+	writeJSON(w, 200, book)
+}
+
+// readCoverPart returns the content of the "cover" form field, enforcing
+// the size limit and the accepted content types.
+func readCoverPart(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, covers.uploadLimit())
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New(`missing "cover" form field`)
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return nil, errCoverTooLarge
+			}
+			return nil, err
+		}
+		if part.FormName() != "cover" {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, covers.maxBytes+1))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) || int64(len(data)) > covers.maxBytes {
+			return nil, errCoverTooLarge
+		}
+		if err != nil {
+			return nil, err
+		}
+		// trust the bytes, not the client supplied Content-Type
+		if _, ok := coverExtensions[http.DetectContentType(data)]; !ok {
+			return nil, errCoverType
+		}
+		return data, nil
+	}
+}
+
+// save writes the original image and its thumbnails under a name derived
+// from the content, so identical uploads share files and the URLs can be
+// cached forever. It returns the file names.
+func (c coverStorage) save(data []byte) (string, map[string]string, error) {
+	contentType := http.DetectContentType(data)
+	ext := coverExtensions[contentType]
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > maxCoverPixels {
+		return "", nil, errCoverType
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, errCoverType
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:16])
+	name := hash + "." + ext
+	if err := writeFileAtomic(filepath.Join(c.dir, name), data); err != nil {
+		return "", nil, err
+	}
+
+	thumbs := make(map[string]string, len(thumbnailSizes))
+	for _, ts := range thumbnailSizes {
+		var buf bytes.Buffer
+		thumb := thumbnail(img, ts.size)
+		thumbExt := "jpg"
+		if ext == "jpg" {
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		} else {
+			// keep transparency of PNG and GIF covers
+			thumbExt = "png"
+			err = png.Encode(&buf, thumb)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("encode %s thumbnail: %w", ts.name, err)
+		}
+		thumbName := hash + "_" + ts.name + "." + thumbExt
+		if err := writeFileAtomic(filepath.Join(c.dir, thumbName), buf.Bytes()); err != nil {
+			return "", nil, err
+		}
+		thumbs[ts.name] = thumbName
+	}
+	return name, thumbs, nil
+}
+
+// baseURL returns the URL prefix under which covers are served.
+func (c coverStorage) baseURL(r *http.Request) string {
+	base := c.publicURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return strings.TrimSuffix(base, "/") + "/covers/"
+}
+
+// handleGetCover serves a stored cover or thumbnail. File names change
+// with the content, so clients may cache them indefinitely.
+func handleGetCover(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !coverNameRe.MatchString(name) {
+		writeError(w, 404, "Cover not found")
+		return
+	}
+	path := filepath.Join(covers.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		writeError(w, 404, "Cover not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, path)
+}
+
+// thumbnail scales img down to fit in a size×size box, keeping the aspect
+// ratio. Each target pixel is the average of the source pixels it covers,
+// which avoids the aliasing of nearest-neighbour sampling. Images that
+// already fit are copied unchanged.
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw, dh = size, max(1, sh*size/sw)
+		} else {
+			dw, dh = max(1, sw*size/sh), size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*sh/dh, b.Min.Y+(y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*sw/dw, b.Min.X+(x+1)*sw/dw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				for sx := x0; sx < max(x1, x0+1); sx++ {
+					c := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					r, g, bl, a = r+uint64(c.R), g+uint64(c.G), bl+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
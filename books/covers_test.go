@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	tests := []struct {
+		w, h, size   int
+		wantW, wantH int
+	}{
+		{800, 600, 150, 150, 112},
+		{100, 400, 150, 37, 150},
+		{1000, 1, 150, 150, 1},
+		{50, 50, 150, 50, 50}, // already fits
+	}
+	for _, tt := range tests {
+		got := thumbnail(image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h)), tt.size).Bounds()
+		if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("thumbnail(%dx%d, %d) is %dx%d, want %dx%d", tt.w, tt.h, tt.size, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+
+	// pixels are averaged, not sampled
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for x := range 2 {
+		img.SetNRGBA(x, 0, color.NRGBA{255, 255, 255, 255})
+		img.SetNRGBA(x, 1, color.NRGBA{0, 0, 0, 255})
+	}
+	if got := thumbnail(img, 1).At(0, 0).(color.NRGBA); got.R < 126 || got.R > 128 || got.A != 255 {
+		t.Errorf("thumbnail of black and white = %v, want gray", got)
+	}
+}
+
+// pngOf encodes a w×h image.
+func pngOf(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// uploadCover posts data as the given form field of a multipart body and
+// returns the response.
+func uploadCover(t *testing.T, srv *httptest.Server, id, field string, data []byte, want int) []byte {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(field, "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	resp, err := srv.Client().Post(srv.URL+"/books/"+id+"/cover", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != want {
+		t.Errorf("upload %s: status %d, want %d: %s", field, resp.StatusCode, want, got)
+	}
+	return got
+}
+
+func TestUploadCover(t *testing.T) {
+	srv, _ := newTestServer(t)
+	saved := covers
+	covers = coverStorage{dir: t.TempDir(), maxBytes: 64 << 10, publicURL: "https://books.example.com/"}
+	t.Cleanup(func() { covers = saved })
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+
+	var book Book
+	if err := json.Unmarshal(uploadCover(t, srv, "a", "cover", pngOf(t, 600, 300), 200), &book); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(book.Imageurl, "https://books.example.com/covers/") || !strings.HasSuffix(book.Imageurl, ".png") {
+		t.Errorf("image_url = %q", book.Imageurl)
+	}
+	for url, want := range map[string]image.Point{book.Imageurl: {600, 300}, book.Thumbnails.Small: {150, 75}, book.Thumbnails.Medium: {400, 200}} {
+		path := strings.TrimPrefix(url, "https://books.example.com")
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != 200 {
+			t.Errorf("GET %s: %d, %v", path, resp.StatusCode, err)
+			continue
+		}
+		if got := (image.Point{cfg.Width, cfg.Height}); got != want {
+			t.Errorf("%s is %v, want %v", path, got, want)
+		}
+		if !strings.Contains(resp.Header.Get("Cache-Control"), "immutable") {
+			t.Errorf("%s: Cache-Control = %q", path, resp.Header.Get("Cache-Control"))
+		}
+	}
+
+	// PUT keeps the thumbnails of the same image and drops them with another
+	replaced := `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99","image_url":"` + book.Imageurl + `"}`
+	send(t, srv, "PUT", "/books/a", replaced, 200)
+	if got, _ := store.Get("a"); got.Thumbnails != book.Thumbnails {
+		t.Errorf("thumbnails after PUT with the same image: %+v", got.Thumbnails)
+	}
+	send(t, srv, "PUT", "/books/a", strings.Replace(replaced, book.Imageurl, "https://example.com/dune.jpg", 1), 200)
+	if got, _ := store.Get("a"); got.Thumbnails != (Thumbnails{}) {
+		t.Errorf("thumbnails after PUT with another image: %+v", got.Thumbnails)
+	}
+}
+
+func TestUploadCoverErrors(t *testing.T) {
+	srv, _ := newTestServer(t)
+	saved := covers
+	covers = coverStorage{dir: t.TempDir(), maxBytes: 4 << 10}
+	t.Cleanup(func() { covers = saved })
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+
+	uploadCover(t, srv, "a", "cover", []byte("just some text"), 415)
+	uploadCover(t, srv, "a", "cover", append(pngOf(t, 1, 1)[:20], make([]byte, 100)...), 415) // a truncated PNG
+	uploadCover(t, srv, "a", "cover", append(pngOf(t, 1, 1), make([]byte, 8<<10)...), 413)
+	uploadCover(t, srv, "a", "image", pngOf(t, 1, 1), 400)
+	uploadCover(t, srv, "missing", "cover", pngOf(t, 1, 1), 404)
+	if book, _ := store.Get("a"); book.Imageurl != "" {
+		t.Errorf("image_url = %q after failed uploads", book.Imageurl)
+	}
+
+	for _, name := range []string{"..%2Fbooks.json", "cover.txt", "0123456789abcdef0123456789abcdef.png"} {
+		resp, err := http.Get(srv.URL + "/covers/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 404 {
+			t.Errorf("GET /covers/%s: status %d, want 404", name, resp.StatusCode)
+		}
+	}
+}
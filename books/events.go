@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriberQueue is the number of events a stream may fall behind before
+// it is closed; the client then resumes with Last-Event-ID.
+const subscriberQueue = 256
+
+// catalogEvents is started in main; it feeds GET /books/events.
+var catalogEvents *eventBroker
+
+// sseKeepalive is how often an idle event stream sends a comment. Set in
+// main.
+var sseKeepalive = 15 * time.Second
+
+// eventBroker fans audit events out to the open event streams and keeps
+// the most recent ones so reconnecting clients can catch up.
+type eventBroker struct {
+	mu     sync.Mutex
+	recent []AuditEvent // oldest first
+	size   int          // most events kept in recent
+	floor  int64        // newest seq no longer in recent; older events cannot be replayed
+	subs   map[chan AuditEvent]struct{}
+	done   chan struct{} // closed on shutdown
+	closed bool
+}
+
+// newEventBroker returns a broker keeping size events. lastSeq is the
+// newest event recorded before the broker started.
+func newEventBroker(size int, lastSeq int64) *eventBroker {
+	return &eventBroker{size: size, floor: lastSeq, subs: map[chan AuditEvent]struct{}{}, done: make(chan struct{})}
+}
+
+// publish passes ev to every stream. It is called by the audit log and
+// must not block, so streams that fell behind are closed instead.
+func (b *eventBroker) publish(ev AuditEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.recent = append(b.recent, ev)
+	if len(b.recent) > b.size {
+		b.floor = b.recent[0].Seq
+		b.recent = b.recent[1:]
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe opens a stream of the events after seq lastID. It returns
+// the buffered events the client missed and whether older ones were lost,
+// in which case the client has to reload the catalog.
+func (b *eventBroker) subscribe(lastID int64) (missed []AuditEvent, ch chan AuditEvent, lost bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch = make(chan AuditEvent, subscriberQueue)
+	if b.closed {
+		close(ch)
+		return nil, ch, false
+	}
+	b.subs[ch] = struct{}{}
+	if lastID < 0 {
+		return nil, ch, false
+	}
+	for _, ev := range b.recent {
+		if ev.Seq > lastID {
+			missed = append(missed, ev)
+		}
+	}
+	return missed, ch, lastID < b.floor
+}
+
+// unsubscribe closes a stream opened by subscribe.
+func (b *eventBroker) unsubscribe(ch chan AuditEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// close ends every stream, so the server does not wait for them when it
+// shuts down.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+}
+
+// writeEvent writes ev in the text/event-stream format. The data is the
+// payload webhooks receive, and the id its seq.
+func writeEvent(w io.Writer, ev AuditEvent) error {
+	data, err := json.Marshal(changePayload(ev))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, webhookEvents[ev.Action], data)
+	return err
+}
+
+// handleBookEvents streams catalog changes as Server-Sent Events. A client
+// that reconnects with Last-Event-ID first gets the events it missed; if
+// they are no longer buffered it gets a "reset" event and should reload
+// the books. A comment is sent every keepalive to hold the connection
+// open through proxies.
+func handleBookEvents(w http.ResponseWriter, r *http.Request) {
+	lastID := int64(-1)
+	if h := strings.TrimSpace(r.Header.Get("Last-Event-ID")); h != "" {
+		id, err := strconv.ParseInt(h, 10, 64)
+		if err != nil || id < 0 {
+			writeError(w, 400, "Invalid Last-Event-ID header", FieldError{"Last-Event-ID", "must be the id of an event"})
+			return
+		}
+		lastID = id
+	}
+	if catalogEvents == nil {
+		writeError(w, 503, "Event stream is not running")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// the stream outlives the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logError(r, err)
+	}
+
+	missed, ch, lost := catalogEvents.subscribe(lastID)
+	defer catalogEvents.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering in nginx
+	w.WriteHeader(200)
+
+	if lost {
+		fmt.Fprintf(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, ev := range missed {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-catalogEvents.done:
+			return
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-ch:
+			if !ok {
+				return // fell behind; the client resumes with Last-Event-ID
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is one event read from a stream.
+type sseEvent struct {
+	id, event, data string
+}
+
+// startEvents runs a broker keeping size events, like main does, until
+// the test ends. Closing it ends the open streams, so the test server
+// can shut down.
+func startEvents(t *testing.T, size int) {
+	t.Helper()
+	catalogEvents = newEventBroker(size, audit.lastSeq)
+	audit.subscribe(catalogEvents.publish)
+	t.Cleanup(catalogEvents.close)
+}
+
+// readEvents opens /books/events with the given Last-Event-ID, or none if
+// empty, calls then once the stream is open, and returns the first n
+// events.
+func readEvents(t *testing.T, srv *httptest.Server, lastID string, n int, then func()) []sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/books/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /books/events: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if then != nil {
+		then()
+	}
+
+	var events []sseEvent
+	var ev sseEvent
+	sc := bufio.NewScanner(resp.Body)
+	for len(events) < n && sc.Scan() {
+		field, value, _ := strings.Cut(sc.Text(), ": ")
+		switch field {
+		case "":
+			if ev != (sseEvent{}) {
+				events = append(events, ev)
+			}
+			ev = sseEvent{}
+		case "id":
+			ev.id = value
+		case "event":
+			ev.event = value
+		case "data":
+			ev.data = value
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("got %d events (%v), want %d", len(events), sc.Err(), n)
+	}
+	return events
+}
+
+func TestBookEventsReplayMissedEvents(t *testing.T) {
+	srv, _ := newTestServer(t)
+	startEvents(t, 10)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	send(t, srv, "PATCH", "/books/a", `{"price":"8.99"}`, 200)
+	send(t, srv, "DELETE", "/books/a", "", 204)
+
+	events := readEvents(t, srv, "1", 2, nil)
+	if events[0].id != "2" || events[0].event != "book.updated" || events[1].id != "3" || events[1].event != "book.deleted" {
+		t.Errorf("replayed %+v, want the update and the deletion", events)
+	}
+	if !strings.Contains(events[0].data, `"8.99"`) {
+		t.Errorf("update payload %s, want the new price", events[0].data)
+	}
+
+	// nothing missed: only new events follow
+	events = readEvents(t, srv, "3", 1, func() {
+		post(t, srv, "/books", `{"id":"b","title":"Emma","author":"Jane Austen","price":"5.00"}`, 201)
+	})
+	if events[0].id != "4" || events[0].event != "book.created" {
+		t.Errorf("got %+v, want the creation of b", events)
+	}
+}
+
+func TestBookEventsResetWhenReplayIsLost(t *testing.T) {
+	srv, _ := newTestServer(t)
+	startEvents(t, 2)
+	for _, id := range []string{"a", "b", "c"} {
+		post(t, srv, "/books", `{"id":"`+id+`","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	}
+
+	events := readEvents(t, srv, "0", 3, nil)
+	if events[0].event != "reset" || events[1].id != "2" || events[2].id != "3" {
+		t.Errorf("got %+v, want a reset and the 2 buffered events", events)
+	}
+	// still buffered
+	if events = readEvents(t, srv, "1", 1, nil); events[0].id != "2" {
+		t.Errorf("got %+v, want event 2 without a reset", events)
+	}
+}
+
+func TestBookEventsRejectsBadLastEventID(t *testing.T) {
+	srv, _ := newTestServer(t)
+	startEvents(t, 10)
+	for _, id := range []string{"x", "-1"} {
+		req, _ := http.NewRequest("GET", srv.URL+"/books/events", nil)
+		req.Header.Set("Last-Event-ID", id)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Errorf("Last-Event-ID %q: status %d, want 400", id, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,1180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// graphqlSchema describes what /graphql serves. It is written by hand and
+// must match gqlTypes; introspection queries are not supported, apart
+// from __typename.
+const graphqlSchema = `type Query {
+  book(id: ID!): Book
+  books(q: String, author: String, authorId: ID, categoryId: ID, minPrice: Float, maxPrice: Float, minRating: Float, currency: String, sort: String, limit: Int, offset: Int): BookPage!
+  author(id: ID!): Author
+  authors: [Author!]!
+  category(id: ID!): Category
+  categories: [Category!]!
+}
+
+type Mutation {
+  addBook(input: BookInput!): Book
+  updateBook(id: ID!, input: BookInput!): Book
+  deleteBook(id: ID!): Boolean
+}
+
+type BookPage {
+  total: Int!
+  items: [Book!]!
+}
+
+type Book {
+  id: ID!
+  title: String!
+  author: String!
+  authorId: ID
+  categories: [Category!]!
+  price(currency: String): Money
+  priceHistory(currency: String): [PricePoint!]!
+  imageUrl: String!
+  rating: Rating!
+  reviews: [Review!]!
+  inventory: Inventory!
+  thumbnails: Thumbnails!
+  history: [AuditEvent!]!
+}
+
+type Author {
+  id: ID!
+  name: String!
+  bio: String
+  books: [Book!]!
+}
+
+type Category {
+  id: ID!
+  name: String!
+  description: String
+  books: [Book!]!
+}
+
+type Rating {
+  average: Float!
+  count: Int!
+}
+
+type Review {
+  id: ID!
+  reviewer: String!
+  rating: Int!
+  text: String
+  createdAt: String!
+}
+
+type Money {
+  amount: String!
+  currency: String!
+}
+
+type PricePoint {
+  seq: Int!
+  time: String!
+  actor: String!
+  price: Money
+}
+
+type Inventory {
+  onHand: Int!
+  reserved: Int!
+  available: Int!
+  reorderThreshold: Int!
+  lowStock: Boolean!
+}
+
+type Thumbnails {
+  small: String
+  medium: String
+}
+
+type AuditEvent {
+  seq: Int!
+  action: String!
+  actor: String!
+  time: String!
+  changedFields: [String!]!
+}
+
+input BookInput {
+  id: ID
+  title: String
+  author: String
+  authorId: ID
+  categoryIds: [ID!]
+  price: String # e.g. "12.50 EUR"
+  imageUrl: String
+}
+`
+
+// gqlRequest is a GraphQL request, sent as the JSON body of a POST or as
+// the query string of a GET.
+type gqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// gqlResponse is the body of every /graphql response. Data is absent when
+// the request failed before execution.
+type gqlResponse struct {
+	Data   any         `json:"data,omitempty"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+type gqlError struct {
+	Message    string         `json:"message"`
+	Locations  []gqlLocation  `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e *gqlError) Error() string {
+	return e.Message
+}
+
+type gqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// gqlValidationError is returned by resolvers whose input fails the Book
+// validation; the field problems end up in the error's extensions.
+type gqlValidationError []FieldError
+
+func (e gqlValidationError) Error() string {
+	return "Validation failed"
+}
+
+// gqlFieldDef is a field of an object type. typ is the GraphQL return
+// type, e.g. "Book", "[Book!]!" or "Int!"; a field returning an object
+// type needs a sub-selection.
+type gqlFieldDef struct {
+	typ     string
+	args    []gqlArgDef
+	resolve func(e *gqlExec, source any, args map[string]any) (any, error)
+	cost    int // towards gqlMaxCost; 0 counts as 1
+}
+
+// Limits on the operations /graphql runs, checked before execution. The
+// schema has cycles (Category.books and Book.categories, Author.books)
+// and every books field scans the catalog, so without them a short query
+// could fan out to millions of resolver calls.
+const (
+	gqlMaxDepth = 8    // nested selection sets
+	gqlMaxCost  = 5000 // estimated by gqlCoster
+	gqlListSize = 10   // items assumed per list when estimating the cost
+	gqlScanCost = 10   // of a field that reads the whole catalog
+)
+
+type gqlArgDef struct {
+	name, typ string
+}
+
+// gqlProp is a field that reads a property of a source of type T.
+func gqlProp[T any](typ string, get func(T) any) gqlFieldDef {
+	return gqlFieldDef{typ: typ, resolve: func(_ *gqlExec, source any, _ map[string]any) (any, error) {
+		return get(source.(T)), nil
+	}}
+}
+
+// gqlBookPage is the result of Query.books.
+type gqlBookPage struct {
+	total int
+	items []Book
+}
+
+// gqlListArgs maps the arguments of Query.books to the query parameters
+// of GET /books, so both share parseListQuery.
+var gqlListArgs = []struct{ arg, param, typ string }{
+	{"q", "q", "String"},
+	{"author", "author", "String"},
+	{"authorId", "author_id", "ID"},
+	{"categoryId", "category_id", "ID"},
+	{"minPrice", "min_price", "Float"},
+	{"maxPrice", "max_price", "Float"},
+	{"minRating", "min_rating", "Float"},
+	{"currency", "currency", "String"},
+	{"sort", "sort", "String"},
+	{"limit", "limit", "Int"},
+	{"offset", "offset", "Int"},
+}
+
+// gqlTypes are the object types of the schema, resolved through the same
+// store, locking and validation as the REST handlers.
+var gqlTypes = map[string]map[string]gqlFieldDef{
+	"Query": {
+		"book": {typ: "Book", args: []gqlArgDef{{"id", "ID!"}}, resolve: resolveBook},
+		"books": {typ: "BookPage!", args: func() []gqlArgDef {
+			var defs []gqlArgDef
+			for _, a := range gqlListArgs {
+				defs = append(defs, gqlArgDef{a.arg, a.typ})
+			}
+			return defs
+		}(), resolve: resolveBooks, cost: gqlScanCost},
+		"author":     {typ: "Author", args: []gqlArgDef{{"id", "ID!"}}, resolve: resolveAuthor},
+		"authors":    {typ: "[Author!]!", resolve: func(*gqlExec, any, map[string]any) (any, error) { return store.ListAuthors() }},
+		"category":   {typ: "Category", args: []gqlArgDef{{"id", "ID!"}}, resolve: resolveCategory},
+		"categories": {typ: "[Category!]!", resolve: func(*gqlExec, any, map[string]any) (any, error) { return store.ListCategories() }},
+	},
+	"Mutation": {
+		"addBook":    {typ: "Book", args: []gqlArgDef{{"input", "BookInput!"}}, resolve: resolveAddBook},
+		"updateBook": {typ: "Book", args: []gqlArgDef{{"id", "ID!"}, {"input", "BookInput!"}}, resolve: resolveUpdateBook},
+		"deleteBook": {typ: "Boolean", args: []gqlArgDef{{"id", "ID!"}}, resolve: resolveDeleteBook},
+	},
+	"BookPage": {
+		"total": gqlProp("Int!", func(p gqlBookPage) any { return p.total }),
+		"items": gqlProp("[Book!]!", func(p gqlBookPage) any { return p.items }),
+	},
+	"Book": {
+		"id":           gqlProp("ID!", func(b Book) any { return b.Id }),
+		"title":        gqlProp("String!", func(b Book) any { return b.Title }),
+		"author":       gqlProp("String!", func(b Book) any { return b.Author }),
+		"authorId":     gqlProp("ID", func(b Book) any { return nullIfEmpty(b.AuthorId) }),
+		"categories":   {typ: "[Category!]!", resolve: resolveBookCategories},
+		"price":        {typ: "Money", args: []gqlArgDef{{"currency", "String"}}, resolve: resolvePrice},
+		"priceHistory": {typ: "[PricePoint!]!", args: []gqlArgDef{{"currency", "String"}}, resolve: resolvePriceHistory},
+		"imageUrl":     gqlProp("String!", func(b Book) any { return b.Imageurl }),
+		"rating":       gqlProp("Rating!", func(b Book) any { return b.Rating }),
+		"reviews":      {typ: "[Review!]!", resolve: resolveBookReviews},
+		"inventory":    gqlProp("Inventory!", func(b Book) any { return b.Inventory }),
+		"thumbnails":   gqlProp("Thumbnails!", func(b Book) any { return b.Thumbnails }),
+		"history":      {typ: "[AuditEvent!]!", resolve: resolveBookHistory},
+	},
+	"Author": {
+		"id":   gqlProp("ID!", func(a Author) any { return a.Id }),
+		"name": gqlProp("String!", func(a Author) any { return a.Name }),
+		"bio":  gqlProp("String", func(a Author) any { return nullIfEmpty(a.Bio) }),
+		"books": {typ: "[Book!]!", cost: gqlScanCost, resolve: func(_ *gqlExec, source any, _ map[string]any) (any, error) {
+			return gqlRelatedBooks(listQuery{authorId: source.(Author).Id})
+		}},
+	},
+	"Category": {
+		"id":          gqlProp("ID!", func(c Category) any { return c.Id }),
+		"name":        gqlProp("String!", func(c Category) any { return c.Name }),
+		"description": gqlProp("String", func(c Category) any { return nullIfEmpty(c.Description) }),
+		"books": {typ: "[Book!]!", cost: gqlScanCost, resolve: func(_ *gqlExec, source any, _ map[string]any) (any, error) {
+			return gqlRelatedBooks(listQuery{categoryId: source.(Category).Id})
+		}},
+	},
+	"Rating": {
+		"average": gqlProp("Float!", func(r RatingSummary) any { return r.Average }),
+		"count":   gqlProp("Int!", func(r RatingSummary) any { return r.Count }),
+	},
+	"Review": {
+		"id":        gqlProp("ID!", func(r Review) any { return r.Id }),
+		"reviewer":  gqlProp("String!", func(r Review) any { return r.Reviewer }),
+		"rating":    gqlProp("Int!", func(r Review) any { return r.Rating }),
+		"text":      gqlProp("String", func(r Review) any { return nullIfEmpty(r.Text) }),
+		"createdAt": gqlProp("String!", func(r Review) any { return r.CreatedAt.Format(time.RFC3339Nano) }),
+	},
+	"Money": {
+		"amount":   gqlProp("String!", func(m Money) any { return m.decimal() }),
+		"currency": gqlProp("String!", func(m Money) any { return m.Currency }),
+	},
+	"PricePoint": {
+		"seq":   gqlProp("Int!", func(p PricePoint) any { return p.Seq }),
+		"time":  gqlProp("String!", func(p PricePoint) any { return p.Time.Format(time.RFC3339Nano) }),
+		"actor": gqlProp("String!", func(p PricePoint) any { return p.Actor }),
+		"price": gqlProp("Money", func(p PricePoint) any { return nullIfZero(p.Price) }),
+	},
+	"Inventory": {
+		"onHand":           gqlProp("Int!", func(inv Inventory) any { return inv.OnHand }),
+		"reserved":         gqlProp("Int!", func(inv Inventory) any { return inv.Reserved }),
+		"available":        gqlProp("Int!", func(inv Inventory) any { return inv.Available() }),
+		"reorderThreshold": gqlProp("Int!", func(inv Inventory) any { return inv.ReorderThreshold }),
+		"lowStock":         gqlProp("Boolean!", func(inv Inventory) any { return inv.LowStock() }),
+	},
+	"Thumbnails": {
+		"small":  gqlProp("String", func(t Thumbnails) any { return nullIfEmpty(t.Small) }),
+		"medium": gqlProp("String", func(t Thumbnails) any { return nullIfEmpty(t.Medium) }),
+	},
+	"AuditEvent": {
+		"seq":    gqlProp("Int!", func(ev AuditEvent) any { return ev.Seq }),
+		"action": gqlProp("String!", func(ev AuditEvent) any { return ev.Action }),
+		"actor":  gqlProp("String!", func(ev AuditEvent) any { return ev.Actor }),
+		"time":   gqlProp("String!", func(ev AuditEvent) any { return ev.Time.Format(time.RFC3339Nano) }),
+		"changedFields": gqlProp("[String!]!", func(ev AuditEvent) any {
+			fields := []string{}
+			for _, c := range ev.Changes {
+				fields = append(fields, c.Field)
+			}
+			return fields
+		}),
+	},
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullIfZero(m Money) any {
+	if m.IsZero() {
+		return nil
+	}
+	return m
+}
+
+// gqlCurrency returns the optional currency argument, checked against the
+// exchange-rate table.
+func gqlCurrency(args map[string]any) (string, error) {
+	currency, _ := args["currency"].(string)
+	currency = strings.ToUpper(currency)
+	if fieldErrs := checkCurrency(currency); fieldErrs != nil {
+		return "", gqlValidationError(fieldErrs)
+	}
+	return currency, nil
+}
+
+func resolvePrice(_ *gqlExec, source any, args map[string]any) (any, error) {
+	currency, err := gqlCurrency(args)
+	if err != nil {
+		return nil, err
+	}
+	price, err := exchangeRates.convert(source.(Book).Price, currency)
+	if err != nil {
+		return nil, err
+	}
+	return nullIfZero(price), nil
+}
+
+func resolvePriceHistory(e *gqlExec, source any, args map[string]any) (any, error) {
+	currency, err := gqlCurrency(args)
+	if err != nil {
+		return nil, err
+	}
+	events, err := e.bookHistory(source.(Book).Id)
+	if err != nil {
+		return nil, err
+	}
+	points := priceHistory(events)
+	for i := range points {
+		if points[i].Price, err = exchangeRates.convert(points[i].Price, currency); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+func resolveBook(_ *gqlExec, _ any, args map[string]any) (any, error) {
+	id, err := gqlString(args, "id")
+	if err != nil {
+		return nil, err
+	}
+	book, err := getLiveBook(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return book, err
+}
+
+func resolveBooks(_ *gqlExec, _ any, args map[string]any) (any, error) {
+	params := url.Values{}
+	for _, a := range gqlListArgs {
+		if v, ok := args[a.arg]; ok && v != nil {
+			params.Set(a.param, fmt.Sprint(v))
+		}
+	}
+	query, fieldErrs := parseListQuery(params)
+	if fieldErrs != nil {
+		// report the GraphQL argument names, not the query parameters
+		for i, fe := range fieldErrs {
+			for _, a := range gqlListArgs {
+				if fe.Field == a.param {
+					fieldErrs[i].Field = a.arg
+				}
+			}
+		}
+		return nil, gqlValidationError(fieldErrs)
+	}
+	books, err := getBooks()
+	if err == nil {
+		books, err = convertPrices(books, query.currency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	page, total, err := query.apply(books)
+	if err != nil {
+		return nil, err
+	}
+	return gqlBookPage{total, page}, nil
+}
+
+func resolveAuthor(_ *gqlExec, _ any, args map[string]any) (any, error) {
+	id, err := gqlString(args, "id")
+	if err != nil {
+		return nil, err
+	}
+	author, err := store.GetAuthor(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return author, err
+}
+
+func resolveCategory(_ *gqlExec, _ any, args map[string]any) (any, error) {
+	id, err := gqlString(args, "id")
+	if err != nil {
+		return nil, err
+	}
+	category, err := store.GetCategory(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return category, err
+}
+
+// resolveBookCategories skips category ids that no longer resolve.
+func resolveBookCategories(_ *gqlExec, source any, _ map[string]any) (any, error) {
+	categories := []Category{}
+	for _, id := range source.(Book).CategoryIds {
+		category, err := store.GetCategory(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
+
+// gqlRelatedBooks returns the live books matching query, e.g. those of an
+// author.
+func gqlRelatedBooks(query listQuery) (any, error) {
+	books, err := getBooks()
+	if err != nil {
+		return nil, err
+	}
+	page, _, err := query.apply(books)
+	return page, err
+}
+
+// resolveBookReviews lists the visible reviews of a book, newest first.
+func resolveBookReviews(_ *gqlExec, source any, _ map[string]any) (any, error) {
+	reviews, err := store.ListReviews(source.(Book).Id)
+	if err != nil {
+		return nil, err
+	}
+	visible := []Review{}
+	for _, r := range slices.Backward(reviews) {
+		if !r.Hidden {
+			visible = append(visible, r)
+		}
+	}
+	return visible, nil
+}
+
+func resolveBookHistory(e *gqlExec, source any, _ map[string]any) (any, error) {
+	events, err := e.bookHistory(source.(Book).Id)
+	if events == nil {
+		events = []AuditEvent{}
+	}
+	return events, err
+}
+
+// resolveAddBook creates a book like POST /books: the id is generated
+// unless the input has one.
+func resolveAddBook(e *gqlExec, _ any, args map[string]any) (any, error) {
+	var book Book
+	if err := applyBookInput(&book, args["input"]); err != nil {
+		return nil, err
+	}
+	if book.Id == "" {
+		book.Id = newID()
+	}
+	if fieldErrs := book.Validate(); fieldErrs != nil {
+		return nil, gqlValidationError(fieldErrs)
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	fieldErrs, err := linkRelations(&book, Book{})
+	if err != nil {
+		return nil, err
+	}
+	if fieldErrs != nil {
+		return nil, gqlValidationError(fieldErrs)
+	}
+	err = storeAs(actorFromContext(e.r.Context())).Create(book)
+	if errors.Is(err, ErrExists) {
+		return nil, errors.New("Book already exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// resolveUpdateBook overwrites the fields present in the input, like
+// PATCH /books/{id}.
+func resolveUpdateBook(e *gqlExec, _ any, args map[string]any) (any, error) {
+	id, err := gqlString(args, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	book, err := getLiveBook(id)
+	if err != nil {
+		return nil, err
+	}
+	current := book
+	if err := applyBookInput(&book, args["input"]); err != nil {
+		return nil, err
+	}
+	book.Id = id
+	preserveServerFields(&book, current)
+	if fieldErrs := book.Validate(); fieldErrs != nil {
+		return nil, gqlValidationError(fieldErrs)
+	}
+	fieldErrs, err := linkRelations(&book, current)
+	if err != nil {
+		return nil, err
+	}
+	if fieldErrs != nil {
+		return nil, gqlValidationError(fieldErrs)
+	}
+	if err := storeAs(actorFromContext(e.r.Context())).Update(book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// resolveDeleteBook moves a book to the trash, like DELETE /books/{id}.
+func resolveDeleteBook(e *gqlExec, _ any, args map[string]any) (any, error) {
+	id, err := gqlString(args, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	book, err := getLiveBook(id)
+	if err != nil {
+		return nil, err
+	}
+	book.DeletedAt = time.Now().UTC()
+	if err := storeAs(actorFromContext(e.r.Context())).Update(book); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// applyBookInput copies the fields of a BookInput object onto book.
+func applyBookInput(book *Book, input any) error {
+	fields, ok := input.(map[string]any)
+	if !ok {
+		return errors.New(`Argument "input" must be a BookInput object`)
+	}
+	var price string
+	targets := map[string]*string{
+		"id":       &book.Id,
+		"title":    &book.Title,
+		"author":   &book.Author,
+		"authorId": &book.AuthorId,
+		"price":    &price,
+		"imageUrl": &book.Imageurl,
+	}
+	for name, v := range fields {
+		if name == "categoryIds" {
+			ids, err := gqlStringList(v, "Field \"categoryIds\" of BookInput")
+			if err != nil {
+				return err
+			}
+			book.CategoryIds = ids
+			continue
+		}
+		dst, ok := targets[name]
+		if !ok {
+			return fmt.Errorf("Field %q is not defined by type BookInput", name)
+		}
+		switch v := v.(type) {
+		case nil:
+			*dst = ""
+		case string:
+			*dst = v
+		default:
+			return fmt.Errorf("Field %q of BookInput must be a string", name)
+		}
+	}
+	if _, ok := fields["price"]; ok {
+		book.Price = priceFromString(price)
+	}
+	return nil
+}
+
+// gqlStringList converts a list value of strings; null yields nil.
+func gqlStringList(v any, what string) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of strings", what)
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of strings", what)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// gqlString returns the string argument name.
+func gqlString(args map[string]any, name string) (string, error) {
+	s, ok := args[name].(string)
+	if !ok {
+		return "", fmt.Errorf("Argument %q must be a string", name)
+	}
+	return s, nil
+}
+
+// handleGraphQL executes a GraphQL query or mutation. Queries may be sent
+// with GET (so read-only credentials can use them) or POST; mutations
+// only with POST.
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req gqlRequest
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeGraphQLError(w, 400, &gqlError{Message: "variables must be a JSON object"})
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeGraphQLError(w, 413, &gqlError{Message: fmt.Sprintf("Request body must not exceed %d bytes", maxErr.Limit)})
+			return
+		}
+		writeGraphQLError(w, 400, &gqlError{Message: "Request body must be a JSON object with a query"})
+		return
+	}
+	if req.Query == "" {
+		writeGraphQLError(w, 400, &gqlError{Message: "Must provide query string"})
+		return
+	}
+
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		writeGraphQLError(w, 400, err.(*gqlError))
+		return
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		writeGraphQLError(w, 400, err.(*gqlError))
+		return
+	}
+	if op.kind == "mutation" && r.Method == http.MethodGet {
+		w.Header().Set("Allow", "POST")
+		writeGraphQLError(w, 405, &gqlError{Message: "Mutations must be sent with POST"})
+		return
+	}
+	if errs := validateGraphQL(doc, op); errs != nil {
+		writeJSON(w, 400, gqlResponse{Errors: errs})
+		return
+	}
+	if err := checkGraphQLCost(doc, op); err != nil {
+		writeGraphQLError(w, 400, err)
+		return
+	}
+	vars, err := coerceVariables(op, req.Variables)
+	if err != nil {
+		writeGraphQLError(w, 400, err.(*gqlError))
+		return
+	}
+
+	e := &gqlExec{r: r, doc: doc, op: op, vars: vars}
+	data := e.selectObject(strings.ToUpper(op.kind[:1])+op.kind[1:], nil, op.sel, nil)
+	writeJSON(w, 200, gqlResponse{Data: data, Errors: e.errors})
+}
+
+// handleGraphQLSchema serves the schema in the GraphQL schema language.
+func handleGraphQLSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(graphqlSchema))
+}
+
+func writeGraphQLError(w http.ResponseWriter, status int, err *gqlError) {
+	writeJSON(w, status, gqlResponse{Errors: []*gqlError{err}})
+}
+
+// coerceVariables applies the defaults of the operation's variables and
+// rejects missing required ones.
+func coerceVariables(op *gqlOperation, given map[string]any) (map[string]any, error) {
+	vars := make(map[string]any, len(op.vars))
+	for _, def := range op.vars {
+		v, ok := given[def.name]
+		switch {
+		case ok:
+			vars[def.name] = v
+		case def.hasDef:
+			vars[def.name] = def.def
+		case strings.HasSuffix(def.typ, "!"):
+			return nil, &gqlError{Message: fmt.Sprintf("Variable \"$%s\" of required type %q was not provided", def.name, def.typ)}
+		}
+		if ok && v == nil && strings.HasSuffix(def.typ, "!") {
+			return nil, &gqlError{Message: fmt.Sprintf("Variable \"$%s\" of non-null type %q must not be null", def.name, def.typ)}
+		}
+	}
+	return vars, nil
+}
+
+// namedType strips list and non-null wrappers, e.g. "[Book!]!" -> "Book".
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// gqlValidator checks an operation against gqlTypes before it runs, so a
+// mistyped field cannot leave a mutation half applied.
+type gqlValidator struct {
+	doc       *gqlDocument
+	errs      []*gqlError
+	visiting  map[string]bool // fragments being validated, to catch cycles
+	validated map[string]bool // fragments checked already, however often spread
+}
+
+func validateGraphQL(doc *gqlDocument, op *gqlOperation) []*gqlError {
+	root := strings.ToUpper(op.kind[:1]) + op.kind[1:]
+	if _, ok := gqlTypes[root]; !ok {
+		return []*gqlError{{Message: "Schema is not configured for " + op.kind + "s"}}
+	}
+	v := &gqlValidator{doc: doc, visiting: make(map[string]bool), validated: make(map[string]bool)}
+	v.selection(root, op.sel)
+	return v.errs
+}
+
+func (v *gqlValidator) errorf(pos int, format string, args ...any) {
+	v.errs = append(v.errs, &gqlError{Message: fmt.Sprintf(format, args...), Locations: []gqlLocation{locationOf(v.doc.src, pos)}})
+}
+
+func (v *gqlValidator) selection(typeName string, sel []gqlSelection) {
+	for _, s := range sel {
+		for _, d := range s.directives {
+			if d.name != "skip" && d.name != "include" {
+				v.errorf(d.pos, "Unknown directive \"@%s\"", d.name)
+			} else if len(d.args) != 1 || d.args[0].name != "if" {
+				v.errorf(d.pos, "Directive \"@%s\" takes a single argument \"if\"", d.name)
+			}
+		}
+
+		switch {
+		case s.spread != "":
+			frag, ok := v.doc.fragments[s.spread]
+			switch {
+			case !ok:
+				v.errorf(s.pos, "Unknown fragment %q", s.spread)
+			case v.visiting[s.spread]:
+				v.errorf(s.pos, "Cannot spread fragment %q within itself", s.spread)
+			case frag.on != typeName:
+				v.errorf(s.pos, "Fragment %q cannot be spread here as objects of type %q can never be of type %q", s.spread, typeName, frag.on)
+			case !v.validated[s.spread]:
+				// a fragment is always spread on the same type, so once
+				// is enough; fragments spreading others twice would
+				// otherwise take exponential time
+				v.validated[s.spread] = true
+				v.visiting[s.spread] = true
+				v.selection(typeName, frag.sel)
+				delete(v.visiting, s.spread)
+			}
+		case s.inline:
+			if s.on != "" && s.on != typeName {
+				v.errorf(s.pos, "Fragment cannot be spread here as objects of type %q can never be of type %q", typeName, s.on)
+				continue
+			}
+			v.selection(typeName, s.sel)
+		case s.name == "__typename":
+			if s.sel != nil {
+				v.errorf(s.pos, "Field \"__typename\" must not have a selection since type \"String!\" has no subfields")
+			}
+		default:
+			v.field(typeName, s)
+		}
+	}
+}
+
+func (v *gqlValidator) field(typeName string, s gqlSelection) {
+	def, ok := gqlTypes[typeName][s.name]
+	if !ok {
+		v.errorf(s.pos, "Cannot query field %q on type %q", s.name, typeName)
+		return
+	}
+	for _, a := range s.args {
+		if !hasArgDef(def.args, a.name) {
+			v.errorf(s.pos, "Unknown argument %q on field \"%s.%s\"", a.name, typeName, s.name)
+		}
+	}
+	for _, a := range def.args {
+		if strings.HasSuffix(a.typ, "!") && !hasArg(s.args, a.name) {
+			v.errorf(s.pos, "Field \"%s.%s\" argument %q of type %q is required, but it was not provided", typeName, s.name, a.name, a.typ)
+		}
+	}
+
+	named := namedType(def.typ)
+	if _, object := gqlTypes[named]; object {
+		if s.sel == nil {
+			v.errorf(s.pos, "Field %q of type %q must have a selection of subfields", s.name, def.typ)
+			return
+		}
+		v.selection(named, s.sel)
+	} else if s.sel != nil {
+		v.errorf(s.pos, "Field %q must not have a selection since type %q has no subfields", s.name, def.typ)
+	}
+}
+
+// gqlCoster measures a valid operation before it runs. Its cost is
+// estimated as: every field costs 1 (or its def.cost) each time it runs,
+// and the selection of a list field runs once per item, gqlListSize
+// times. Fragments are expanded, so the estimate stops at the first limit
+// exceeded rather than walking all of them.
+type gqlCoster struct {
+	doc       *gqlDocument
+	fragments map[string][2]int // depth and position by fragment, see depth
+	cost      int
+	err       *gqlError
+}
+
+// checkGraphQLCost rejects operations nested deeper than gqlMaxDepth or
+// costing more than gqlMaxCost. @skip and @include are not evaluated, so
+// every field counts.
+func checkGraphQLCost(doc *gqlDocument, op *gqlOperation) *gqlError {
+	c := &gqlCoster{doc: doc, fragments: make(map[string][2]int)}
+	if depth, pos := c.depth(op.sel); depth+1 > gqlMaxDepth {
+		return &gqlError{Message: fmt.Sprintf("Query is nested %d levels deep, more than %d", depth+1, gqlMaxDepth), Locations: []gqlLocation{locationOf(doc.src, pos)}}
+	}
+	c.selection(strings.ToUpper(op.kind[:1])+op.kind[1:], op.sel, 1)
+	return c.err
+}
+
+// depth returns how many selection sets are nested in sel and the
+// position of the innermost field. Each fragment is measured once.
+func (c *gqlCoster) depth(sel []gqlSelection) (int, int) {
+	deepest, pos := 0, 0
+	for _, s := range sel {
+		var d, p int
+		switch {
+		case s.spread != "":
+			m, ok := c.fragments[s.spread]
+			if !ok {
+				m[0], m[1] = c.depth(c.doc.fragments[s.spread].sel)
+				c.fragments[s.spread] = m
+			}
+			d, p = m[0], m[1]
+		case s.inline:
+			d, p = c.depth(s.sel)
+		case s.sel != nil:
+			if d, p = c.depth(s.sel); d == 0 {
+				p = s.pos
+			}
+			d++
+		}
+		if d > deepest {
+			deepest, pos = d, p
+		}
+	}
+	return deepest, pos
+}
+
+// selection adds the cost of running sel on an object of type typeName
+// times times.
+func (c *gqlCoster) selection(typeName string, sel []gqlSelection, times int) {
+	for _, s := range sel {
+		if c.err != nil {
+			return
+		}
+		switch {
+		case s.spread != "":
+			c.selection(typeName, c.doc.fragments[s.spread].sel, times)
+		case s.inline:
+			c.selection(typeName, s.sel, times)
+		case s.name == "__typename":
+			c.add(s.pos, times)
+		default:
+			def := gqlTypes[typeName][s.name]
+			c.add(s.pos, times*max(def.cost, 1))
+			if s.sel == nil {
+				continue
+			}
+			n := times
+			if strings.HasPrefix(def.typ, "[") {
+				n *= gqlListSize
+			}
+			c.selection(namedType(def.typ), s.sel, n)
+		}
+	}
+}
+
+func (c *gqlCoster) add(pos, cost int) {
+	if c.cost += cost; c.cost > gqlMaxCost {
+		c.err = &gqlError{Message: fmt.Sprintf("Query is too expensive: its estimated cost exceeds %d", gqlMaxCost), Locations: []gqlLocation{locationOf(c.doc.src, pos)}}
+	}
+}
+
+func hasArgDef(defs []gqlArgDef, name string) bool {
+	for _, d := range defs {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasArg(args []gqlArg, name string) bool {
+	for _, a := range args {
+		if a.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// gqlExec executes one validated operation. Field errors are collected
+// and the failing field is returned as null.
+type gqlExec struct {
+	r      *http.Request
+	doc    *gqlDocument
+	op     *gqlOperation
+	vars   map[string]any
+	errors []*gqlError
+
+	// the audit log by book, read once per request for the history
+	// fields and again only after a change was recorded
+	history    map[string][]AuditEvent
+	historySeq int64
+}
+
+// bookHistory is audit.history for the books of one request.
+func (e *gqlExec) bookHistory(id string) ([]AuditEvent, error) {
+	if audit == nil {
+		return nil, nil
+	}
+	if seq := audit.seq(); e.history == nil || seq != e.historySeq {
+		events, err := audit.read()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		e.history = map[string][]AuditEvent{}
+		for _, ev := range events {
+			e.history[ev.BookId] = append(e.history[ev.BookId], ev)
+		}
+		e.historySeq = seq
+	}
+	return slices.Clone(e.history[id]), nil
+}
+
+// gqlResult is a JSON object that keeps its keys in selection order.
+type gqlResult []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value any
+}
+
+func (res gqlResult) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, e := range res {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.key)
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// gqlCollected is one response key with every selection merged into it.
+type gqlCollected struct {
+	field gqlSelection
+	sel   []gqlSelection
+}
+
+// collectFields flattens fragments and drops fields excluded by @skip or
+// @include, merging fields that share a response key.
+func (e *gqlExec) collectFields(sel []gqlSelection, out []*gqlCollected) []*gqlCollected {
+	for _, s := range sel {
+		if !e.included(s.directives) {
+			continue
+		}
+		switch {
+		case s.spread != "":
+			out = e.collectFields(e.doc.fragments[s.spread].sel, out)
+		case s.inline:
+			out = e.collectFields(s.sel, out)
+		default:
+			merged := false
+			for _, c := range out {
+				if c.field.key() == s.key() {
+					c.sel = append(c.sel, s.sel...)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				out = append(out, &gqlCollected{field: s, sel: s.sel})
+			}
+		}
+	}
+	return out
+}
+
+func (e *gqlExec) included(dirs []gqlDirective) bool {
+	for _, d := range dirs {
+		v, err := e.value(d.args[0].value)
+		cond, ok := v.(bool)
+		if err != nil || !ok {
+			e.errors = append(e.errors, &gqlError{Message: fmt.Sprintf("Argument \"if\" of @%s must be a Boolean", d.name)})
+			return false
+		}
+		if (d.name == "skip") == cond {
+			return false
+		}
+	}
+	return true
+}
+
+// selectObject resolves the selection sel on source, an object of type
+// typeName.
+func (e *gqlExec) selectObject(typeName string, source any, sel []gqlSelection, path []any) gqlResult {
+	res := gqlResult{}
+	for _, c := range e.collectFields(sel, nil) {
+		key := c.field.key()
+		if c.field.name == "__typename" {
+			res = append(res, gqlEntry{key, typeName})
+			continue
+		}
+		fieldPath := append(append([]any{}, path...), key)
+		def := gqlTypes[typeName][c.field.name]
+
+		args := make(map[string]any, len(c.field.args))
+		var err error
+		for _, a := range c.field.args {
+			if args[a.name], err = e.value(a.value); err != nil {
+				break
+			}
+		}
+		var v any
+		if err == nil {
+			v, err = def.resolve(e, source, args)
+		}
+		if err != nil {
+			e.fieldError(err, fieldPath)
+			res = append(res, gqlEntry{key, nil})
+			continue
+		}
+		res = append(res, gqlEntry{key, e.complete(def.typ, v, c.sel, fieldPath)})
+	}
+	return res
+}
+
+// complete turns a resolved value of type typ into its JSON form.
+func (e *gqlExec) complete(typ string, v any, sel []gqlSelection, path []any) any {
+	if v == nil {
+		return nil
+	}
+	typ = strings.TrimSuffix(typ, "!")
+	if strings.HasPrefix(typ, "[") {
+		list := reflect.ValueOf(v)
+		out := make([]any, list.Len())
+		for i := range out {
+			out[i] = e.complete(typ[1:len(typ)-1], list.Index(i).Interface(), sel, append(append([]any{}, path...), i))
+		}
+		return out
+	}
+	if _, object := gqlTypes[typ]; object {
+		return e.selectObject(typ, v, sel, path)
+	}
+	return v
+}
+
+func (e *gqlExec) fieldError(err error, path []any) {
+	gerr := &gqlError{Message: err.Error(), Path: path}
+	var verr gqlValidationError
+	switch {
+	case errors.As(err, &verr):
+		gerr.Extensions = map[string]any{"fields": []FieldError(verr)}
+	case errors.Is(err, ErrNotFound):
+		gerr.Message = "Book Not found"
+	}
+	e.errors = append(e.errors, gerr)
+}
+
+// value substitutes the variables in an argument value.
+func (e *gqlExec) value(v any) (any, error) {
+	switch v := v.(type) {
+	case gqlVar:
+		val, ok := e.vars[string(v)]
+		if !ok && !e.declared(string(v)) {
+			return nil, fmt.Errorf("Variable \"$%s\" is not defined", v)
+		}
+		return val, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			if out[i], err = e.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			var err error
+			if out[k], err = e.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func (e *gqlExec) declared(name string) bool {
+	for _, def := range e.op.vars {
+		if def.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gqlTestResponse is a decoded /graphql response.
+type gqlTestResponse struct {
+	Data   map[string]json.RawMessage
+	Errors []struct {
+		Message    string
+		Locations  []gqlLocation
+		Path       []any
+		Extensions struct{ Fields []FieldError }
+	}
+}
+
+// graphql posts query with vars to the test server, fails the test unless
+// the response has the status want and returns the decoded response.
+func graphql(t *testing.T, srv *httptest.Server, query string, vars map[string]any, want int) gqlTestResponse {
+	t.Helper()
+	body, err := json.Marshal(gqlRequest{Query: query, Variables: vars})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res gqlTestResponse
+	if err := json.Unmarshal(post(t, srv, "/graphql", string(body), want), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// messages returns the error messages of res.
+func (res gqlTestResponse) messages() []string {
+	var msgs []string
+	for _, e := range res.Errors {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+// seedGraphQL adds two books, the first filed under a category.
+func seedGraphQL(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	post(t, srv, "/categories", `{"id":"sf","name":"Science Fiction"}`, 201)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99","category_ids":["sf"]}`, 201)
+	post(t, srv, "/books", `{"id":"b","title":"Emma","author":"Jane Austen","price":"5.00"}`, 201)
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		src, want string
+		line, col int
+	}{
+		{`{ book(id: "a") { title }`, `Expected Name, found <EOF>`, 1, 26},
+		{`{ title" }`, `Unterminated string`, 1, 8},
+		{"{\n  books ? }", `Unexpected character '?'`, 2, 9},
+		{`{ books(limit: 99999999999999999999) { total } }`, `Int 99999999999999999999 is out of range`, 1, 16},
+		{`query ($a: Int = $b) { books { total } }`, `Unexpected "$"`, 1, 18},
+		{`query ($a Int) { books { total } }`, `Expected ":", found "Int"`, 1, 11},
+		{`fragment on on Book { id } { books { total } }`, `Unexpected "on"`, 1, 10},
+		{`fragment F on Book { id } fragment F on Book { title } { books { total } }`, `There can be only one fragment named "F"`, 1, 27},
+		{`fragment F on Book { id }`, `Document contains no operation`, 0, 0},
+		{`type Query { id: ID }`, `Unexpected "type"`, 1, 1},
+		{`{ book(id: "\q") { id } }`, `Syntax Error`, 1, 12},
+	}
+	for _, tt := range tests {
+		_, err := parseGraphQL(tt.src)
+		var gerr *gqlError
+		if !errors.As(err, &gerr) || !strings.Contains(gerr.Message, tt.want) {
+			t.Errorf("parseGraphQL(%q) = %v, want %q", tt.src, err, tt.want)
+			continue
+		}
+		if tt.line != 0 && (len(gerr.Locations) != 1 || gerr.Locations[0] != gqlLocation{tt.line, tt.col}) {
+			t.Errorf("parseGraphQL(%q) at %v, want %d:%d", tt.src, gerr.Locations, tt.line, tt.col)
+		}
+	}
+}
+
+func TestGraphQLValidation(t *testing.T) {
+	srv, _ := newTestServer(t)
+	seedGraphQL(t, srv)
+
+	tests := []struct{ query, want string }{
+		{`{ book(id: "a") { isbn } }`, `Cannot query field "isbn" on type "Book"`},
+		{`{ book { title } }`, `argument "id" of type "ID!" is required`},
+		{`{ book(id: "a", lang: "en") { title } }`, `Unknown argument "lang"`},
+		{`{ book(id: "a") }`, `must have a selection of subfields`},
+		{`{ book(id: "a") { title { x } } }`, `must not have a selection`},
+		{`{ book(id: "a") { ...Missing } }`, `Unknown fragment "Missing"`},
+		{`{ book(id: "a") { ...A } } fragment A on Book { ...B } fragment B on Book { title ...A }`, `Cannot spread fragment "A" within itself`},
+		{`{ book(id: "a") { ...C } } fragment C on Category { name }`, `can never be of type "Category"`},
+		{`{ book(id: "a") { title @upper } }`, `Unknown directive "@upper"`},
+		{`{ book(id: "a") { title @skip } }`, `takes a single argument "if"`},
+		{`subscription { books { total } }`, `Schema is not configured for subscriptions`},
+		{`query A { books { total } } query B { authors { id } }`, `Must provide operation name`},
+	}
+	for _, tt := range tests {
+		res := graphql(t, srv, tt.query, nil, 400)
+		if res.Data != nil || !slices.ContainsFunc(res.messages(), func(m string) bool { return strings.Contains(m, tt.want) }) {
+			t.Errorf("%s: errors %q, want %q", tt.query, res.messages(), tt.want)
+		}
+	}
+
+	// errors point at the offending field
+	res := graphql(t, srv, "{\n  book(id: \"a\") {\n    isbn\n  }\n}", nil, 400)
+	if len(res.Errors) != 1 || !slices.Equal(res.Errors[0].Locations, []gqlLocation{{3, 5}}) {
+		t.Errorf("errors = %+v, want one at 3:5", res.Errors)
+	}
+
+	// a valid operation is picked by name
+	body, _ := json.Marshal(gqlRequest{Query: `query A { books { total } } query B { authors { id } }`, OperationName: "B"})
+	if err := json.Unmarshal(post(t, srv, "/graphql", string(body), 200), &res); err != nil || string(res.Data["authors"]) == "" {
+		t.Errorf("operation B: %+v, %v", res, err)
+	}
+	// mutations need POST
+	send(t, srv, "GET", "/graphql?query="+url.QueryEscape(`mutation { deleteBook(id: "a") }`), "", 405)
+	send(t, srv, "GET", "/books/a", "", 200)
+}
+
+func TestGraphQLVariables(t *testing.T) {
+	srv, _ := newTestServer(t)
+	seedGraphQL(t, srv)
+	query := `query Book($id: ID!, $currency: String = "USD", $limit: Int) {
+		book(id: $id) { title price(currency: $currency) { amount currency } }
+		books(limit: $limit, sort: "title") { total items { id } }
+	}`
+
+	res := graphql(t, srv, query, map[string]any{"id": "b", "limit": 1}, 200)
+	want := `{"title":"Emma","price":{"amount":"5.00","currency":"USD"}}`
+	if res.Errors != nil || string(res.Data["book"]) != want || string(res.Data["books"]) != `{"total":2,"items":[{"id":"a"}]}` {
+		t.Errorf("data = %s %s, errors %q", res.Data["book"], res.Data["books"], res.messages())
+	}
+
+	for _, vars := range []map[string]any{nil, {"id": nil}} {
+		res := graphql(t, srv, query, vars, 400)
+		if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, `"$id"`) || res.Data != nil {
+			t.Errorf("variables %v: %+v, want an error about $id", vars, res)
+		}
+	}
+
+	// undeclared variables fail only their field
+	res = graphql(t, srv, `{ a: book(id: $nope) { title } b: book(id: "a") { title } }`, nil, 200)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, `"$nope" is not defined`) || string(res.Data["a"]) != "null" || string(res.Data["b"]) != `{"title":"Dune"}` {
+		t.Errorf("undeclared variable: %+v", res)
+	}
+
+	// GET takes the variables as JSON in the query string
+	get := "/graphql?query=" + url.QueryEscape(`query($id: ID!) { book(id: $id) { title } }`)
+	if err := json.Unmarshal(send(t, srv, "GET", get+"&variables="+url.QueryEscape(`{"id":"a"}`), "", 200), &res); err != nil || string(res.Data["book"]) != `{"title":"Dune"}` {
+		t.Errorf("GET: %+v, %v", res, err)
+	}
+	send(t, srv, "GET", get+"&variables=[1]", "", 400)
+}
+
+func TestGraphQLSkipAndInclude(t *testing.T) {
+	srv, _ := newTestServer(t)
+	seedGraphQL(t, srv)
+	query := `query($yes: Boolean!) {
+		book(id: "a") {
+			id @skip(if: true)
+			title @include(if: $yes)
+			author @include(if: false)
+			...Price @skip(if: $yes)
+			... @include(if: $yes) { authorId: id }
+			... on Book @skip(if: false) { categories { id } }
+		}
+	}
+	fragment Price on Book { price { amount } }`
+
+	for yes, want := range map[bool]string{
+		true:  `{"title":"Dune","authorId":"a","categories":[{"id":"sf"}]}`,
+		false: `{"price":{"amount":"9.99"},"categories":[{"id":"sf"}]}`,
+	} {
+		res := graphql(t, srv, query, map[string]any{"yes": yes}, 200)
+		if res.Errors != nil || string(res.Data["book"]) != want {
+			t.Errorf("$yes = %v: %s, errors %q, want %s", yes, res.Data["book"], res.messages(), want)
+		}
+	}
+
+	res := graphql(t, srv, `{ book(id: "a") { title @skip(if: "yes") id } }`, nil, 200)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "must be a Boolean") || string(res.Data["book"]) != `{"id":"a"}` {
+		t.Errorf("non-boolean if: %s, errors %q", res.Data["book"], res.messages())
+	}
+}
+
+func TestGraphQLFieldMerging(t *testing.T) {
+	srv, _ := newTestServer(t)
+	seedGraphQL(t, srv)
+	query := `{
+		book(id: "a") { title categories { id } }
+		other: book(id: "b") { title }
+		book(id: "a") { price { amount } categories { name } ...More }
+	}
+	fragment More on Book { title author }`
+
+	res := graphql(t, srv, query, nil, 200)
+	if res.Errors != nil {
+		t.Fatalf("errors %q", res.messages())
+	}
+	// one key per response name, in the order of first appearance
+	want := `{"title":"Dune","categories":[{"id":"sf","name":"Science Fiction"}],"price":{"amount":"9.99"},"author":"Frank Herbert"}`
+	if got := string(res.Data["book"]); got != want {
+		t.Errorf("book = %s, want %s", got, want)
+	}
+	if got := string(res.Data["other"]); got != `{"title":"Emma"}` {
+		t.Errorf("other = %s", got)
+	}
+	raw := send(t, srv, "GET", "/graphql?query="+url.QueryEscape(query), "", 200)
+	if !strings.HasPrefix(string(raw), `{"data":{"book":`) || !strings.Contains(string(raw), `},"other":`) {
+		t.Errorf("keys out of order: %s", raw)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	srv, _ := newTestServer(t)
+	seedGraphQL(t, srv)
+
+	add := `mutation($input: BookInput!) { addBook(input: $input) { id title author authorId categories { id } price { amount currency } } }`
+	input := map[string]any{"id": "c", "title": "Ulysses", "author": "James Joyce", "price": "7.50 EUR", "categoryIds": []any{"sf"}}
+	res := graphql(t, srv, add, map[string]any{"input": input}, 200)
+	var added struct {
+		Id, Title, Author, AuthorId string
+		Categories                  []Category
+		Price                       struct{ Amount, Currency string }
+	}
+	if err := json.Unmarshal(res.Data["addBook"], &added); err != nil || res.Errors != nil {
+		t.Fatalf("addBook: %s, errors %q", res.Data["addBook"], res.messages())
+	}
+	if added.Id != "c" || added.AuthorId == "" || len(added.Categories) != 1 || added.Price.Amount != "7.50" || added.Price.Currency != "EUR" {
+		t.Errorf("added = %+v", added)
+	}
+	if book, err := store.Get("c"); err != nil || book.Title != "Ulysses" {
+		t.Errorf("stored: %+v, %v", book, err)
+	}
+
+	// field errors null the field and carry its path
+	res = graphql(t, srv, add, map[string]any{"input": input}, 200)
+	if string(res.Data["addBook"]) != "null" || len(res.Errors) != 1 || res.Errors[0].Message != "Book already exists" || !slices.Equal(res.Errors[0].Path, []any{"addBook"}) {
+		t.Errorf("duplicate: %s, %+v", res.Data["addBook"], res.Errors)
+	}
+	res = graphql(t, srv, add, map[string]any{"input": map[string]any{"title": "", "price": "cheap", "categoryIds": []any{"nope"}}}, 200)
+	var fields []string
+	for _, e := range res.Errors {
+		for _, fe := range e.Extensions.Fields {
+			fields = append(fields, fe.Field)
+		}
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Message != "Validation failed" || !slices.Contains(fields, "title") || !slices.Contains(fields, "price") {
+		t.Errorf("invalid input: %+v", res.Errors)
+	}
+	res = graphql(t, srv, add, map[string]any{"input": map[string]any{"title": "X", "isbn": "1"}}, 200)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, `"isbn" is not defined by type BookInput`) {
+		t.Errorf("unknown input field: %q", res.messages())
+	}
+
+	// mutations run in order; a failing one does not stop the others
+	res = graphql(t, srv, `mutation {
+		update: updateBook(id: "a", input: {title: "Dune Messiah", price: null}) { title price { amount } categories { id } }
+		missing: updateBook(id: "zzz", input: {title: "X"}) { title }
+		delete: deleteBook(id: "b")
+		again: deleteBook(id: "b")
+	}`, nil, 200)
+	if got := string(res.Data["update"]); got != `{"title":"Dune Messiah","price":null,"categories":[{"id":"sf"}]}` {
+		t.Errorf("update = %s", got)
+	}
+	if string(res.Data["delete"]) != "true" || string(res.Data["missing"]) != "null" || string(res.Data["again"]) != "null" {
+		t.Errorf("data = %v", res.Data)
+	}
+	var paths []string
+	for _, e := range res.Errors {
+		paths = append(paths, fmt.Sprint(e.Path, " ", e.Message))
+	}
+	if want := []string{"[missing] Book Not found", "[again] Book Not found"}; !slices.Equal(paths, want) {
+		t.Errorf("errors = %q, want %q", paths, want)
+	}
+	send(t, srv, "GET", "/books/b", "", 404)
+}
+
+func TestGraphQLFieldErrors(t *testing.T) {
+	srv, _ := newTestServer(t)
+	seedGraphQL(t, srv)
+
+	res := graphql(t, srv, `{
+		books(sort: "title") { items { id price(currency: "ZZZ") { amount } } }
+		bad: books(limit: -1) { total }
+		book(id: "missing") { title }
+	}`, nil, 200)
+	if got := string(res.Data["books"]); got != `{"items":[{"id":"a","price":null},{"id":"b","price":null}]}` {
+		t.Errorf("books = %s", got)
+	}
+	// a missing book is null without an error
+	if string(res.Data["bad"]) != "null" || string(res.Data["book"]) != "null" {
+		t.Errorf("bad = %s, book = %s", res.Data["bad"], res.Data["book"])
+	}
+	var got []string
+	for _, e := range res.Errors {
+		var fields []string
+		for _, fe := range e.Extensions.Fields {
+			fields = append(fields, fe.Field)
+		}
+		got = append(got, fmt.Sprint(e.Path, fields))
+	}
+	want := []string{"[books items 0 price] [currency]", "[books items 1 price] [currency]", "[bad] [limit]"}
+	if !slices.Equal(got, want) {
+		t.Errorf("errors = %q, want %q", got, want)
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	srv, _ := newTestServer(t)
+	seedGraphQL(t, srv)
+
+	// each level of the cycle scans the catalog once per category
+	cycle := `{ categories { books { categories { books { categories { books { id } } } } } } }`
+	res := graphql(t, srv, cycle, nil, 400)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "too expensive") || res.Data != nil {
+		t.Errorf("cycle: %q", res.messages())
+	}
+
+	deep := `{ books { items { categories { books { categories { books { categories { books { id } } } } } } } } }`
+	res = graphql(t, srv, deep, nil, 400)
+	if len(res.Errors) != 1 || res.Errors[0].Message != "Query is nested 9 levels deep, more than 8" || !slices.Equal(res.Errors[0].Locations, []gqlLocation{{1, 74}}) {
+		t.Errorf("deep: %q", res.messages())
+	}
+
+	// every fragment spreads the next one twice: 2^40 fields
+	var b strings.Builder
+	b.WriteString(`{ book(id: "a") { ...F0 } }`)
+	for i := range 40 {
+		fmt.Fprintf(&b, " fragment F%d on Book { ...F%d ...F%d }", i, i+1, i+1)
+	}
+	b.WriteString(" fragment F40 on Book { id }")
+	start := time.Now()
+	res = graphql(t, srv, b.String(), nil, 400)
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, "too expensive") {
+		t.Errorf("fragments: %q", res.messages())
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("fragments took %v to reject", d)
+	}
+
+	// nothing runs once the operation is rejected
+	graphql(t, srv, `mutation { addBook(input: {id: "c", title: "Ulysses", author: "James Joyce", price: "7.50"}) { `+strings.Repeat("categories { books { ", 3)+"id"+strings.Repeat(" } }", 3)+` } }`, nil, 400)
+	send(t, srv, "GET", "/books/c", "", 404)
+
+	// ordinary queries stay well within the limits
+	res = graphql(t, srv, `{ books { total items { id title price { amount } rating { average } reviews { text } categories { name } } }
+		authors { name books { title } } categories { name books { title } } }`, nil, 200)
+	if res.Errors != nil {
+		t.Errorf("ordinary query: %q", res.messages())
+	}
+}
+
+func TestGraphQLHistory(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	post(t, srv, "/books", `{"id":"b","title":"Emma","author":"Jane Austen","price":"5.00"}`, 201)
+	send(t, srv, "PATCH", "/books/a", `{"price":"12.50"}`, 200)
+
+	// the history read for the first field must not hide the change
+	// made by the second
+	res := graphql(t, srv, `mutation {
+		first: updateBook(id: "b", input: {title: "Emma!"}) { history { action } }
+		second: updateBook(id: "b", input: {price: "6.00"}) { history { action } priceHistory { price { amount } } }
+	}`, nil, 200)
+	if res.Errors != nil {
+		t.Fatalf("errors: %q", res.messages())
+	}
+	var first, second struct {
+		History      []struct{ Action string }
+		PriceHistory []struct{ Price struct{ Amount string } }
+	}
+	json.Unmarshal(res.Data["first"], &first)
+	json.Unmarshal(res.Data["second"], &second)
+	if n := len(first.History); n != 2 {
+		t.Errorf("first: %d events, want 2", n)
+	}
+	if n := len(second.History); n != 3 {
+		t.Errorf("second: %d events, want 3", n)
+	}
+	if n := len(second.PriceHistory); n != 2 {
+		t.Errorf("second: %d price points, want 2", n)
+	}
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file parses GraphQL query documents: operations with variables,
+// fields with aliases and arguments, named and inline fragments and
+// directives. Type system definitions (schema, type, ...) are rejected.
+
+// gqlDocument is a parsed query document.
+type gqlDocument struct {
+	src       string
+	ops       []*gqlOperation
+	fragments map[string]*gqlFragment
+}
+
+// gqlOperation is a query or mutation. kind is "query" for the shorthand
+// form "{ ... }".
+type gqlOperation struct {
+	kind string
+	name string
+	vars []gqlVarDef
+	sel  []gqlSelection
+}
+
+type gqlVarDef struct {
+	name   string
+	typ    string // e.g. "Int" or "[ID!]!"
+	def    any
+	hasDef bool
+}
+
+type gqlFragment struct {
+	name string
+	on   string
+	sel  []gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (spread != "") or an inline
+// fragment (inline is set, on may be empty).
+type gqlSelection struct {
+	pos        int
+	alias      string
+	name       string
+	args       []gqlArg
+	directives []gqlDirective
+	sel        []gqlSelection
+
+	spread string
+	inline bool
+	on     string
+}
+
+// key returns the name of the field in the response.
+func (s *gqlSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+type gqlArg struct {
+	name  string
+	value any // literal, gqlVar, []any or map[string]any
+}
+
+type gqlDirective struct {
+	pos  int
+	name string
+	args []gqlArg
+}
+
+// gqlVar is a reference to an operation variable inside a value.
+type gqlVar string
+
+// operation picks the operation to execute: the one called name, or the
+// only one in the document when name is empty.
+func (d *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(d.ops) > 1 {
+			return nil, &gqlError{Message: "Must provide operation name if query contains multiple operations"}
+		}
+		return d.ops[0], nil
+	}
+	for _, op := range d.ops {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &gqlError{Message: fmt.Sprintf("Unknown operation named %q", name)}
+}
+
+type gqlTokenKind int
+
+const (
+	tokEOF gqlTokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type gqlToken struct {
+	kind gqlTokenKind
+	val  string
+	pos  int
+}
+
+// parseGraphQL parses src into a document with at least one operation.
+func parseGraphQL(src string) (*gqlDocument, error) {
+	toks, err := lexGraphQL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{src: src, toks: toks}
+	doc := &gqlDocument{src: src, fragments: make(map[string]*gqlFragment)}
+	for p.peek().kind != tokEOF {
+		switch t := p.peek(); {
+		case p.is("{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.ops = append(doc.ops, &gqlOperation{kind: "query", sel: sel})
+		case p.is("query"), p.is("mutation"), p.is("subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.ops = append(doc.ops, op)
+		case p.is("fragment"):
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := doc.fragments[frag.name]; dup {
+				return nil, p.errorf(t, "There can be only one fragment named %q", frag.name)
+			}
+			doc.fragments[frag.name] = frag
+		default:
+			return nil, p.unexpected(t)
+		}
+	}
+	if len(doc.ops) == 0 {
+		return nil, &gqlError{Message: "Document contains no operation"}
+	}
+	return doc, nil
+}
+
+type gqlParser struct {
+	src  string
+	toks []gqlToken
+	i    int
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.toks[p.i]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuator or name val.
+func (p *gqlParser) is(val string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokName) && t.val == val
+}
+
+func (p *gqlParser) expect(val string) error {
+	if !p.is(val) {
+		return p.errorf(p.peek(), "Expected %q, found %s", val, describeToken(p.peek()))
+	}
+	p.next()
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	t := p.next()
+	if t.kind != tokName {
+		return "", p.errorf(t, "Expected Name, found %s", describeToken(t))
+	}
+	return t.val, nil
+}
+
+func (p *gqlParser) errorf(t gqlToken, format string, args ...any) error {
+	return &gqlError{
+		Message:   "Syntax Error: " + fmt.Sprintf(format, args...),
+		Locations: []gqlLocation{locationOf(p.src, t.pos)},
+	}
+}
+
+func (p *gqlParser) unexpected(t gqlToken) error {
+	return p.errorf(t, "Unexpected %s", describeToken(t))
+}
+
+func describeToken(t gqlToken) string {
+	if t.kind == tokEOF {
+		return "<EOF>"
+	}
+	return strconv.Quote(t.val)
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{kind: p.next().val}
+	if p.peek().kind == tokName {
+		op.name = p.next().val
+	}
+	if p.is("(") {
+		p.next()
+		for !p.is(")") {
+			v, err := p.varDef()
+			if err != nil {
+				return nil, err
+			}
+			op.vars = append(op.vars, v)
+		}
+		p.next()
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.sel = sel
+	return op, nil
+}
+
+func (p *gqlParser) varDef() (gqlVarDef, error) {
+	var v gqlVarDef
+	if err := p.expect("$"); err != nil {
+		return v, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return v, err
+	}
+	v.name = name
+	if err := p.expect(":"); err != nil {
+		return v, err
+	}
+	if v.typ, err = p.typeRef(); err != nil {
+		return v, err
+	}
+	if p.is("=") {
+		p.next()
+		if v.def, err = p.value(true); err != nil {
+			return v, err
+		}
+		v.hasDef = true
+	}
+	_, err = p.directives()
+	return v, err
+}
+
+func (p *gqlParser) typeRef() (string, error) {
+	var typ string
+	if p.is("[") {
+		p.next()
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.is("!") {
+		p.next()
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *gqlParser) fragment() (*gqlFragment, error) {
+	p.next() // "fragment"
+	t := p.peek()
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, p.unexpected(t)
+	}
+	if err := p.expect("on"); err != nil {
+		return nil, err
+	}
+	on, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &gqlFragment{name: name, on: on, sel: sel}, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sel []gqlSelection
+	for {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, s)
+		if p.is("}") {
+			p.next()
+			return sel, nil
+		}
+	}
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	s := gqlSelection{pos: p.peek().pos}
+	var err error
+	if p.is("...") {
+		p.next()
+		switch {
+		case p.is("on"):
+			p.next()
+			if s.on, err = p.name(); err != nil {
+				return s, err
+			}
+			s.inline = true
+		case p.peek().kind == tokName:
+			s.spread = p.next().val
+		default:
+			s.inline = true
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return s, err
+		}
+		if s.inline {
+			s.sel, err = p.selectionSet()
+		}
+		return s, err
+	}
+
+	if s.name, err = p.name(); err != nil {
+		return s, err
+	}
+	if p.is(":") {
+		p.next()
+		s.alias = s.name
+		if s.name, err = p.name(); err != nil {
+			return s, err
+		}
+	}
+	if s.args, err = p.arguments(false); err != nil {
+		return s, err
+	}
+	if s.directives, err = p.directives(); err != nil {
+		return s, err
+	}
+	if p.is("{") {
+		s.sel, err = p.selectionSet()
+	}
+	return s, err
+}
+
+func (p *gqlParser) arguments(constant bool) ([]gqlArg, error) {
+	if !p.is("(") {
+		return nil, nil
+	}
+	p.next()
+	var args []gqlArg
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, gqlArg{name, v})
+		if p.is(")") {
+			p.next()
+			return args, nil
+		}
+	}
+}
+
+func (p *gqlParser) directives() ([]gqlDirective, error) {
+	var dirs []gqlDirective
+	for p.is("@") {
+		d := gqlDirective{pos: p.next().pos}
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.args, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// value parses an input value. Enum values are returned as strings.
+// Variables are not allowed in constant values such as defaults.
+func (p *gqlParser) value(constant bool) (any, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "Int %s is out of range", t.val)
+		}
+		return n, nil
+	case tokFloat:
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, p.errorf(t, "Float %s is out of range", t.val)
+		}
+		return f, nil
+	case tokString:
+		return t.val, nil
+	case tokName:
+		switch t.val {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.val, nil
+	}
+
+	switch t.val {
+	case "$":
+		if constant {
+			return nil, p.unexpected(t)
+		}
+		name, err := p.name()
+		return gqlVar(name), err
+	case "[":
+		list := []any{}
+		for !p.is("]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.next()
+		return list, nil
+	case "{":
+		obj := map[string]any{}
+		for !p.is("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		p.next()
+		return obj, nil
+	}
+	return nil, p.unexpected(t)
+}
+
+// lexGraphQL splits src into tokens, dropping whitespace, commas and
+// comments. The token list always ends with a tokEOF.
+func lexGraphQL(src string) ([]gqlToken, error) {
+	var toks []gqlToken
+	lexErr := func(pos int, msg string) error {
+		return &gqlError{Message: "Syntax Error: " + msg, Locations: []gqlLocation{locationOf(src, pos)}}
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			toks = append(toks, gqlToken{tokPunct, "...", i})
+			i += 3
+		case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+			toks = append(toks, gqlToken{tokPunct, string(c), i})
+			i++
+		case c == '_' || isASCIILetter(c):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || isASCIILetter(src[j]) || isASCIIDigit(src[j])) {
+				j++
+			}
+			toks = append(toks, gqlToken{tokName, src[i:j], i})
+			i = j
+		case c == '-' || isASCIIDigit(c):
+			j := i
+			if src[j] == '-' {
+				j++
+			}
+			digits := j
+			for j < len(src) && isASCIIDigit(src[j]) {
+				j++
+			}
+			if j == digits {
+				return nil, lexErr(i, "Invalid number, expected digit")
+			}
+			kind := tokInt
+			if j < len(src) && src[j] == '.' {
+				kind = tokFloat
+				j++
+				for j < len(src) && isASCIIDigit(src[j]) {
+					j++
+				}
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				kind = tokFloat
+				j++
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				for j < len(src) && isASCIIDigit(src[j]) {
+					j++
+				}
+			}
+			toks = append(toks, gqlToken{kind, src[i:j], i})
+			i = j
+		case strings.HasPrefix(src[i:], `"""`):
+			end := strings.Index(src[i+3:], `"""`)
+			if end < 0 {
+				return nil, lexErr(i, "Unterminated string")
+			}
+			toks = append(toks, gqlToken{tokString, blockStringValue(src[i+3 : i+3+end]), i})
+			i += end + 6
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' && src[j] != '\n' && src[j] != '\r' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != '"' {
+				return nil, lexErr(i, "Unterminated string")
+			}
+			s, err := unescapeGraphQL(src[i+1 : j])
+			if err != nil {
+				return nil, lexErr(i, err.Error())
+			}
+			toks = append(toks, gqlToken{tokString, s, i})
+			i = j + 1
+		default:
+			return nil, lexErr(i, fmt.Sprintf("Unexpected character %q", c))
+		}
+	}
+	return append(toks, gqlToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isASCIIDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// unescapeGraphQL resolves the escape sequences of a quoted string.
+func unescapeGraphQL(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case '"', '\\', '/':
+			b.WriteByte(s[i])
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if i+4 >= len(s) {
+				return "", fmt.Errorf("Invalid Unicode escape sequence")
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("Invalid Unicode escape sequence %q", s[i-1:i+5])
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			return "", fmt.Errorf("Invalid escape sequence %q", s[i-1:i+1])
+		}
+	}
+	return b.String(), nil
+}
+
+// blockStringValue strips the common indentation and the leading and
+// trailing blank lines of a """block string""".
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		lines[i] = lines[i][min(indent, len(lines[i])):]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// locationOf converts a byte offset into a 1-based line and column.
+func locationOf(src string, pos int) gqlLocation {
+	line := 1 + strings.Count(src[:pos], "\n")
+	col := pos - strings.LastIndexByte(src[:pos], '\n')
+	return gqlLocation{Line: line, Column: col}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	idMu     sync.Mutex
+	idLastMS int64
+	idSeq    uint16
+)
+
+// newID returns a UUIDv7 (RFC 9562): a 48-bit millisecond timestamp
+// followed by random bits. The 12-bit rand_a field is used as a counter
+// within the same millisecond, so ids minted by this process sort in
+// creation order.
+func newID() string {
+	idMu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms > idLastMS {
+		idLastMS = ms
+		var seed [2]byte
+		rand.Read(seed[:])
+		idSeq = binary.BigEndian.Uint16(seed[:]) & 0x7ff // leave headroom for the counter
+	} else {
+		idSeq++
+		if idSeq > 0xfff {
+			idLastMS++
+			idSeq = 0
+		}
+	}
+	ms, seq := idLastMS, idSeq
+	idMu.Unlock()
+
+	var u [16]byte
+	binary.BigEndian.PutUint64(u[0:8], uint64(ms)<<16)
+	u[6] = 0x70 | byte(seq>>8)
+	u[7] = byte(seq)
+	rand.Read(u[8:])
+	u[8] = 0x80 | u[8]&0x3f
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// assignMissingIDs mints an id for every book submitted without one.
+func assignMissingIDs(books []Book) {
+	for i := range books {
+		if strings.TrimSpace(books[i].Id) == "" {
+			books[i].Id = newID()
+		}
+	}
+}
+
+// similarityKey normalizes title and author so that books differing only
+// in case, punctuation or spacing map to the same key, e.g.
+// "Atomic  Habits!" by "james clear" and "atomic habits" by "James Clear".
+func similarityKey(b Book) string {
+	if b.Title == "" && b.Author == "" {
+		return ""
+	}
+	return normalizeName(b.Title) + "|" + normalizeName(b.Author)
+}
+
+// normalizeName lowercases s, drops punctuation and collapses spaces, so
+// that "James  Clear." and "james clear" compare equal.
+func normalizeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return unicode.ToLower(r)
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return -1
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Inventory tracks the stock of a book. Available stock is OnHand minus
+// Reserved; a book is low on stock once that drops to ReorderThreshold.
+type Inventory struct {
+	OnHand           int `json:"on_hand"`
+	Reserved         int `json:"reserved"`
+	ReorderThreshold int `json:"reorder_threshold"`
+}
+
+// ErrInsufficientStock is returned when a stock change would leave fewer
+// units than are reserved.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// Available returns the units that can still be reserved.
+func (inv Inventory) Available() int {
+	return inv.OnHand - inv.Reserved
+}
+
+// LowStock reports whether the book should be reordered. Books without a
+// reorder threshold are never low on stock.
+func (inv Inventory) LowStock() bool {
+	return inv.ReorderThreshold > 0 && inv.Available() <= inv.ReorderThreshold
+}
+
+// Reserve sets aside qty units, e.g. for an open cart.
+func (inv *Inventory) Reserve(qty int) error {
+	if qty > inv.Available() {
+		return fmt.Errorf("%w: %d available, %d requested", ErrInsufficientStock, inv.Available(), qty)
+	}
+	inv.Reserved += qty
+	return nil
+}
+
+// Release returns qty previously reserved units to the available stock.
+func (inv *Inventory) Release(qty int) error {
+	if qty > inv.Reserved {
+		return fmt.Errorf("%w: only %d reserved", ErrInsufficientStock, inv.Reserved)
+	}
+	inv.Reserved -= qty
+	return nil
+}
+
+// Adjust adds delta (negative to remove) units to the stock on hand.
+func (inv *Inventory) Adjust(delta int) error {
+	if inv.OnHand+delta < inv.Reserved {
+		return fmt.Errorf("%w: %d available, %d requested", ErrInsufficientStock, inv.Available(), -delta)
+	}
+	inv.OnHand += delta
+	return nil
+}
+
+// StockLevel is the inventory of one book as returned by the API.
+type StockLevel struct {
+	BookId string `json:"book_id"`
+	Title  string `json:"title"`
+	Inventory
+	Available int  `json:"available"`
+	LowStock  bool `json:"low_stock"`
+}
+
+func stockLevelOf(b Book) StockLevel {
+	return StockLevel{b.Id, b.Title, b.Inventory, b.Inventory.Available(), b.Inventory.LowStock()}
+}
+
+// changeInventory applies change to the inventory of book id and persists
+// it on behalf of actor. The caller must hold mutateMu.
+func changeInventory(actor, id string, change func(inv *Inventory) error) (Book, error) {
+	book, err := getLiveBook(id)
+	if err != nil {
+		return Book{}, err
+	}
+	if err := change(&book.Inventory); err != nil {
+		return Book{}, err
+	}
+	if err := storeAs(actor).Update(book); err != nil {
+		return Book{}, err
+	}
+	return book, nil
+}
+
+// StockChange is the body of the reserve, release and adjust endpoints.
+type StockChange struct {
+	Quantity         int  `json:"quantity"`                    // reserve, release: units, > 0
+	Delta            int  `json:"delta"`                       // adjust: units added (or removed if negative)
+	ReorderThreshold *int `json:"reorder_threshold,omitempty"` // adjust: optional new threshold
+}
+
+func handleGetInventory(w http.ResponseWriter, r *http.Request) {
+	book, err := getLiveBook(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, 200, stockLevelOf(book))
+}
+
+// handleStockChange serves POST /books/{id}/inventory/{action} where
+// action is reserve, release or adjust.
+func handleStockChange(w http.ResponseWriter, r *http.Request) {
+	var req StockChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+
+	var (
+		change    func(inv *Inventory) error
+		fieldErrs []FieldError
+	)
+	switch action := r.PathValue("action"); action {
+	case "reserve", "release":
+		if req.Quantity <= 0 {
+			fieldErrs = append(fieldErrs, FieldError{"quantity", "must be a positive integer"})
+		}
+		change = func(inv *Inventory) error {
+			if action == "reserve" {
+				return inv.Reserve(req.Quantity)
+			}
+			return inv.Release(req.Quantity)
+		}
+	case "adjust":
+		if req.ReorderThreshold != nil && *req.ReorderThreshold < 0 {
+			fieldErrs = append(fieldErrs, FieldError{"reorder_threshold", "must not be negative"})
+		}
+		change = func(inv *Inventory) error {
+			if req.ReorderThreshold != nil {
+				inv.ReorderThreshold = *req.ReorderThreshold
+			}
+			return inv.Adjust(req.Delta)
+		}
+	default:
+		writeError(w, 404, "Unknown inventory action "+action)
+		return
+	}
+	if fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+
+	mutateMu.Lock()
+	book, err := changeInventory(actorFromContext(r.Context()), r.PathValue("id"), change)
+	mutateMu.Unlock()
+	if errors.Is(err, ErrInsufficientStock) {
+		writeError(w, 409, err.Error())
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJSON(w, 200, stockLevelOf(book))
+}
+
+// handleLowStock lists the books whose available stock is at or below
+// their reorder threshold, lowest first.
+func handleLowStock(w http.ResponseWriter, r *http.Request) {
+	books, err := getBooks()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	levels := []StockLevel{}
+	for _, b := range books {
+		if b.Inventory.LowStock() {
+			levels = append(levels, stockLevelOf(b))
+		}
+	}
+	slices.SortStableFunc(levels, func(a, b StockLevel) int { return a.Available - b.Available })
+	writeJSON(w, 200, levels)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// catalogCache is the parsed catalog file shared by the readers of a
+// JSONStore. It must not be modified; mutate changes a clone and replaces
+// the cache.
+type catalogCache struct {
+	catalogFile
+	bookIndex map[string]int // position in Books by id
+	stamp     fileStamp      // version of the file the catalog was read from or written to
+}
+
+// fileStamp identifies a version of the catalog file on disk.
+type fileStamp struct {
+	modTime int64 // unix nanoseconds
+	size    int64
+}
+
+func statCatalog(path string) (fileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{fi.ModTime().UnixNano(), fi.Size()}, nil
+}
+
+func newCatalogCache(c catalogFile, stamp fileStamp) *catalogCache {
+	index := make(map[string]int, len(c.Books))
+	for i, b := range c.Books {
+		index[b.Id] = i
+	}
+	return &catalogCache{c, index, stamp}
+}
+
+// clone copies c, down to the slices inside its books, reviews, carts
+// and orders, so the copy can be changed without affecting c.
+func (c catalogFile) clone() catalogFile {
+	return catalogFile{
+		Books:      cloneEach(c.Books),
+		Authors:    slices.Clone(c.Authors),
+		Categories: slices.Clone(c.Categories),
+		Reviews:    cloneEach(c.Reviews),
+		Carts:      cloneEach(c.Carts),
+		Orders:     cloneEach(c.Orders),
+	}
+}
+
+// cloneEach copies list and every element of it. The readers of a
+// JSONStore return such copies: callers change what they get, e.g. by
+// decoding a PATCH into a book, and must not change the cache.
+func cloneEach[T interface{ clone() T }](list []T) []T {
+	if list == nil {
+		return nil
+	}
+	out := make([]T, len(list))
+	for i, v := range list {
+		out[i] = v.clone()
+	}
+	return out
+}
+
+func (b Book) clone() Book {
+	b.CategoryIds = slices.Clone(b.CategoryIds)
+	return b
+}
+
+func (r Review) clone() Review {
+	r.Flags = slices.Clone(r.Flags)
+	return r
+}
+
+func (c Cart) clone() Cart {
+	c.Items = slices.Clone(c.Items)
+	return c
+}
+
+func (o Order) clone() Order {
+	o.Lines = slices.Clone(o.Lines)
+	return o
+}
+
+// read returns the cached catalog, parsing the file if there is none.
+func (s *JSONStore) read() (*catalogCache, error) {
+	s.cacheMu.RLock()
+	c := s.cache
+	s.cacheMu.RUnlock()
+	if c != nil {
+		return c, nil
+	}
+
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if s.cache != nil {
+		return s.cache, nil // loaded while we waited for the lock
+	}
+	// stat first: a change while the file is read is caught by the next check
+	stamp, err := statCatalog(s.path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := readCatalog(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.cache = newCatalogCache(file, stamp)
+	return s.cache, nil
+}
+
+// cached reports whether the cache holds the version stamp of the file.
+func (s *JSONStore) cached(stamp fileStamp) bool {
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	return s.cache != nil && s.cache.stamp == stamp
+}
+
+// remember caches c after mutate wrote it to the file.
+func (s *JSONStore) remember(c catalogFile) {
+	stamp, err := statCatalog(s.path)
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if err != nil {
+		s.cache = nil
+		return
+	}
+	s.cache = newCatalogCache(c, stamp)
+}
+
+// invalidate drops the cache; the next read parses the file again.
+func (s *JSONStore) invalidate() {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cache = nil
+}
+
+// Watch checks the catalog file every interval until ctx is done and
+// drops the cache when somebody else changed the file, e.g. an editor.
+// changed is called after every such change.
+func (s *JSONStore) Watch(ctx context.Context, interval time.Duration, changed func()) {
+	seen, _ := statCatalog(s.path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// hold off mutations, so our own writes are not taken for outside ones
+		s.mu.Lock()
+		stamp, err := statCatalog(s.path)
+		outside := err == nil && stamp != seen && !s.cached(stamp)
+		if outside {
+			s.invalidate()
+		}
+		s.mu.Unlock()
+
+		// a missing file is most likely being replaced; look again next time
+		if err != nil || stamp == seen {
+			continue
+		}
+		seen = stamp
+		if outside {
+			log.Printf("%s changed on disk, reloading the catalog", s.path)
+			changed()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRejectedPatchLeavesCacheUnchanged(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/categories", `{"id":"sf","name":"Science Fiction"}`, 201)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99","category_ids":["sf"]}`, 201)
+	send(t, srv, "PATCH", "/books/a", `{"category_ids":["bogus"]}`, 400)
+
+	book, err := store.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(book.CategoryIds, []string{"sf"}) {
+		t.Errorf("category_ids = %q after a rejected PATCH, want [sf]", book.CategoryIds)
+	}
+}
+
+// run with -race: updating a cart must not write to the cart other
+// requests are reading from the cache
+func TestCartUpdatesDoNotShareCache(t *testing.T) {
+	newTestServer(t)
+	if err := store.Create(Book{Id: "a", Title: "Dune", Author: "Frank Herbert", Price: Money{Amount: 999, Currency: "USD"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveCart(Cart{Id: "c", Items: []CartItem{{"a", 1}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Go(func() {
+			req := httptest.NewRequest("PUT", "/carts/c/items/a", strings.NewReader(fmt.Sprintf(`{"quantity":%d}`, i+1)))
+			req.SetPathValue("id", "c")
+			req.SetPathValue("bookId", "a")
+			handleSetCartItem(httptest.NewRecorder(), req)
+		})
+		wg.Go(func() {
+			req := httptest.NewRequest("GET", "/carts/c", nil)
+			req.SetPathValue("id", "c")
+			handleGetCart(httptest.NewRecorder(), req)
+		})
+	}
+	wg.Wait()
+}
+
+// newBenchStore returns a JSON store of n generated books and the id of
+// the last one, the worst case of a scan.
+func newBenchStore(b *testing.B, n int) (*JSONStore, string) {
+	b.Helper()
+	path := filepath.Join(b.TempDir(), "books.json")
+	c := catalogFile{Books: make([]Book, n)}
+	for i := range c.Books {
+		c.Books[i] = Book{
+			Id:          newID(),
+			Title:       fmt.Sprintf("Book %d", i),
+			Author:      fmt.Sprintf("Author %d", i%50),
+			Price:       Money{Amount: int64(500 + i), Currency: "USD"},
+			CategoryIds: []string{"fiction"},
+		}
+	}
+	if err := saveCatalog(path, c); err != nil {
+		b.Fatal(err)
+	}
+	return NewJSONStore(path), c.Books[n-1].Id
+}
+
+// The uncached benchmarks read the file on every call, as the store did
+// before the catalog was cached.
+
+func BenchmarkJSONStoreGet(b *testing.B) {
+	s, id := newBenchStore(b, 1000)
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			c, err := readCatalog(s.path)
+			if err == nil {
+				_, err = findByID(c.Books, id, func(b Book) string { return b.Id })
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		s.read() // parse the file before the clock starts
+		for b.Loop() {
+			if _, err := s.Get(id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkJSONStoreList(b *testing.B) {
+	s, _ := newBenchStore(b, 1000)
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := readCatalog(s.path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		s.read() // parse the file before the clock starts
+		for b.Loop() {
+			if _, err := s.List(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxBulkBooks is the largest number of books accepted by one /add or
+// /books/import request; 0 means no limit. Set in main.
+var maxBulkBooks = 1000
+
+// rateLimiter throttles each client with a token bucket: a client may
+// send burst requests at once and then rate requests per second.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate requests per second with
+// bursts of burst requests, or nil (no limit) if rate is not positive.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the bucket of client. If none is left it
+// reports how long the client has to wait for the next one.
+func (l *rateLimiter) allow(client string, now time.Time) (ok bool, remaining int, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, found := l.buckets[client]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return false, 0, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// sweep forgets the buckets that have refilled completely, at most once a
+// minute, so the map does not grow with every client ever seen.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// middleware answers 429 with Retry-After once a client has used up its
+// bucket. Clients are told apart by their IP address.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, remaining, retryAfter := l.allow(clientIP(r), time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(l.burst)))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, 429, "Too many requests, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bodyLimits lists the routes that accept larger bodies than the server
+// wide limit, e.g. cover uploads.
+var bodyLimits = map[string]func() int64{
+	"POST /books/{id}/cover": func() int64 { return covers.uploadLimit() },
+}
+
+// limitBody caps request bodies at maxBytes (or the route's own limit in
+// bodyLimits). Bodies announcing a larger Content-Length are rejected
+// right away; others fail once the handler reads past the limit.
+func limitBody(mux *http.ServeMux, maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxBytes
+		if _, pattern := mux.Handler(r); bodyLimits[pattern] != nil {
+			limit = bodyLimits[pattern]()
+		}
+		if r.ContentLength > limit {
+			writeError(w, 413, fmt.Sprintf("Request body must not exceed %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyError answers 413 if err comes from reading past the body
+// limit and 400 with msg otherwise.
+func writeBodyError(w http.ResponseWriter, err error, msg string) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeError(w, 413, fmt.Sprintf("Request body must not exceed %d bytes", maxErr.Limit))
+		return
+	}
+	writeError(w, 400, msg)
+}
+
+// tooManyBooks answers 413 if a bulk request carries more than
+// maxBulkBooks books.
+func tooManyBooks(w http.ResponseWriter, n int) bool {
+	if maxBulkBooks > 0 && n > maxBulkBooks {
+		writeError(w, 413, fmt.Sprintf("At most %d books may be sent in one request, got %d", maxBulkBooks, n))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Unix(1700000000, 0)
+	for i := range 3 {
+		if ok, remaining, _ := l.allow("a", now); !ok || remaining != 2-i {
+			t.Fatalf("request %d: ok %v, %d remaining", i+1, ok, remaining)
+		}
+	}
+	ok, _, retryAfter := l.allow("a", now)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("over the burst: ok %v, retry after %v, want 500ms", ok, retryAfter)
+	}
+	// other clients have their own bucket
+	if ok, _, _ := l.allow("b", now); !ok {
+		t.Error("b was limited by a's requests")
+	}
+	// a token every 500ms, up to the burst
+	if ok, _, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("no token after 500ms")
+	}
+	if ok, _, _ := l.allow("a", now.Add(600*time.Millisecond)); ok {
+		t.Error("a second token after 600ms")
+	}
+	if _, remaining, _ := l.allow("a", now.Add(time.Hour)); remaining != 2 {
+		t.Errorf("after an hour: %d remaining, want the burst minus one", remaining)
+	}
+
+	// refilled buckets are forgotten
+	l.allow("c", now.Add(time.Hour))
+	l.allow("d", now.Add(2*time.Hour))
+	if _, ok := l.buckets["c"]; ok || len(l.buckets) != 1 {
+		t.Errorf("buckets after the sweep: %v, want only d", l.buckets)
+	}
+
+	if newRateLimiter(0, 10) != nil {
+		t.Error("a rate of 0 limits requests")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	h := newRateLimiter(0.5, 2).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, want := range []struct {
+		status    int
+		remaining string
+	}{{200, "1"}, {200, "0"}, {429, "0"}} {
+		req := httptest.NewRequest("GET", "/books", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want.status || rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Remaining") != want.remaining {
+			t.Errorf("request %d: %d, headers %v", i+1, rec.Code, rec.Header())
+		}
+		if rec.Code == 429 && rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("Retry-After = %q, want 2", rec.Header().Get("Retry-After"))
+		}
+	}
+
+	// the port does not make a new client
+	req := httptest.NewRequest("GET", "/books", nil)
+	req.RemoteAddr = "192.0.2.1:5678"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 429 {
+		t.Errorf("same IP, other port: %d, want 429", rec.Code)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	newTestServer(t)
+	saved, savedBulk := covers, maxBulkBooks
+	covers = coverStorage{dir: t.TempDir(), maxBytes: 4 << 10}
+	maxBulkBooks = 2
+	t.Cleanup(func() { covers, maxBulkBooks = saved, savedBulk })
+
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.pattern, rt.handler)
+	}
+	srv := httptest.NewServer(limitBody(mux, 256, mux))
+	defer srv.Close()
+
+	book := `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`
+	padded := `{"id":"b","title":"` + strings.Repeat("x", 300) + `","author":"Frank Herbert","price":"9.99"}`
+	tests := []struct {
+		name, path, body string
+		chunked          bool
+		want             int
+	}{
+		{"within the limit", "/books", book, false, 201},
+		{"Content-Length over the limit", "/books", padded, false, 413},
+		{"chunked body over the limit", "/books", padded, true, 413},
+		{"too many books", "/add", "[" + strings.Repeat(book+",", 2) + book + "]", false, 413},
+		// cover uploads have their own, larger limit
+		{"cover over the server limit", "/books/a/cover", strings.Repeat("x", 1024), false, 400},
+		{"cover over the cover limit", "/books/a/cover", strings.Repeat("x", 128<<10), false, 413},
+	}
+	for _, tt := range tests {
+		var body io.Reader = strings.NewReader(tt.body)
+		if tt.chunked {
+			body = io.MultiReader(body) // hides the length
+		}
+		req, _ := http.NewRequest("POST", srv.URL+tt.path, body)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, resp.StatusCode, tt.want, msg)
+		}
+	}
+	if _, err := store.Get("b"); err == nil {
+		t.Error("the book over the limit was saved")
+	}
+}
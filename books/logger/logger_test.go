@@ -0,0 +1,42 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLoggerReportsCaller(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger("info", zapcore.AddSync(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithContext(context.Background(), WithRequestID(l, "r1"))
+	FromContext(ctx, nil).Info("hello")
+	l.Debug("hidden")
+
+	var entry struct {
+		Level, Msg, Caller string
+		ReqID              string `json:"req_id"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	if entry.Level != "INFO" || entry.Msg != "hello" || entry.ReqID != "r1" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if !strings.HasPrefix(entry.Caller, "logger/logger_test.go:") {
+		t.Errorf("caller = %q, want this test", entry.Caller)
+	}
+}
+
+func TestNewRejectsUnknownLevel(t *testing.T) {
+	if _, err := New("verbose"); err == nil {
+		t.Error("New(verbose) accepted")
+	}
+}
@@ -1,33 +1,68 @@
 package main
 
 import (
+	"books/logger"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
 )
 
 type Book struct {
 	Id       string `json:"id"`
 	Title    string `json:"title"`
-	Author   string `json:"author"`
-	Price    string `json:"price"`
+	Author   string `json:"author"` // name of the author, kept in sync with AuthorId
+	Price    Money  `json:"price,omitzero"`
 	Imageurl string `json:"image_url"`
-}
 
-const PORT string = ":8080"
+	AuthorId    string   `json:"author_id,omitempty"`
+	CategoryIds []string `json:"category_ids,omitempty"`
+
+	// server-managed fields, see preserveServerFields
+	Inventory  Inventory     `json:"inventory,omitzero"`
+	Thumbnails Thumbnails    `json:"thumbnails,omitzero"`
+	Rating     RatingSummary `json:"rating,omitzero"`     // of the visible reviews, see refreshRating
+	DeletedAt  time.Time     `json:"deleted_at,omitzero"` // set while the book is in the trash
+}
 
 type Message struct {
 	Msg string
 }
 
-func jsonMessageByte(msg string) []byte {
-	errrMessage := Message{msg}
-	byteContent, _ := json.Marshal(errrMessage)
+// ErrorResponse is the body of every error response. Errors lists the
+// individual field problems when a request body fails validation.
+type ErrorResponse struct {
+	Msg    string
+	Errors []FieldError `json:",omitempty"`
+}
+
+func jsonErrorByte(msg string, fieldErrs ...FieldError) []byte {
+	byteContent, _ := json.Marshal(ErrorResponse{msg, fieldErrs})
 	return byteContent
 }
 
+// AddReport is the response of /add: the books that were created and the
+// ones rejected as duplicates.
+type AddReport struct {
+	Message
+	Created  []Book         `json:"created"`
+	Rejected []RejectedBook `json:"rejected"`
+}
+
 func checkError(err error) {
 	if err != nil {
 		log.Printf("Error - %v", err)
@@ -37,34 +72,217 @@ func checkError(err error) {
 
 func main() {
 
-	// http://localhost:8080
-	http.HandleFunc("/", handleGetBooks)
+	// books import|export ... work on the store without starting the server
+	if len(os.Args) > 1 && (os.Args[1] == "import" || os.Args[1] == "export") {
+		os.Exit(runCLI(os.Args[1], os.Args[2:]))
+	}
 
-	// http://localhost:8080/book?id=1
-	http.HandleFunc("/book", handleGetBookById)
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.MintToken != "" {
+		if cfg.TokenSecret == "" {
+			log.Fatal("-mint-token needs -token-secret or BOOKS_TOKEN_SECRET")
+		}
+		subject, scope, ok := strings.Cut(cfg.MintToken, ":")
+		if !ok {
+			scope = scopeWrite
+		}
+		if scope != scopeRead && scope != scopeWrite {
+			log.Fatalf("invalid token scope %q", scope)
+		}
+		fmt.Println(signToken([]byte(cfg.TokenSecret), subject, scope, time.Now().Add(cfg.TokenTTL)))
+		return
+	}
+
+	lg, err := logger.New(cfg.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer logger.Flush(lg)
+	baseLogger = lg
+	// route the log.Printf calls of background jobs through the structured
+	// logger; handlers log with the request's logger, see requestLog
+	zap.RedirectStdLog(lg)
 
-	// http://localhost:8080/add
-	http.HandleFunc("/add", handleAddBook)
+	auth, err := newAuthConfig(cfg.APIKeys, cfg.TokenSecret, cfg.PublicReads)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !auth.enabled() {
+		if !cfg.NoAuth {
+			log.Fatal(errNoCredentials)
+		}
+		lg.Warn("-insecure-no-auth: no API keys or token secret configured, write routes are unprotected")
+	}
 
-	fmt.Printf("App is listening on %v\n", PORT)
+	defaultCurrency = cfg.DefaultCurrency
+	exchangeRates = ExchangeRates{Base: defaultCurrency, Rates: map[string]float64{}}
+	if cfg.ExchangeRatesPath != "" {
+		if exchangeRates, err = loadExchangeRates(cfg.ExchangeRatesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	err := http.ListenAndServe(PORT, nil)
-	// stop the app is any error to start the server
+	backend, err := openStore(cfg.StoreKind, cfg.DataPath)
 	if err != nil {
 		log.Fatal(err)
 	}
+	versioned := newVersionedStore(backend)
+	store = versioned
+
+	if audit, err = openAuditLog(cfg.AuditPath); err != nil {
+		log.Fatal(err)
+	}
+	if hookLog, err = openWebhookLog(cfg.WebhookLogPath); err != nil {
+		log.Fatal(err)
+	}
+
+	covers = coverStorage{dir: cfg.CoverDir, maxBytes: cfg.MaxCoverBytes, publicURL: cfg.PublicURL}
+	if err := os.MkdirAll(covers.dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	trashRetention = cfg.TrashRetention
+	maxBulkBooks = cfg.MaxBulkBooks
+	for _, m := range strings.Split(cfg.Moderators, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			moderators = append(moderators, m)
+		}
+	}
+
+	// books stored before authors were resources only carry a name
+	if n, err := migrateAuthors(); err != nil {
+		log.Fatal(err)
+	} else if n > 0 {
+		lg.Info("linked books to author records", zap.Int("books", n))
+	}
+
+	// refuse to start with routes the OpenAPI document does not describe
+	if err := checkOpenAPISpec(routes); err != nil {
+		log.Fatal(err)
+	}
+	for _, rt := range routes {
+		http.HandleFunc(rt.pattern, rt.handler)
+	}
+
+	// throttle clients before checking their credentials, cap bodies after
+	handler := limitBody(http.DefaultServeMux, cfg.MaxBodyBytes, http.DefaultServeMux)
+	handler = auth.middleware(handler)
+	handler = newRateLimiter(cfg.RateLimit, cfg.RateBurst).middleware(handler)
+
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      requestLogger(lg, http.DefaultServeMux, handler),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// a second signal kills the process immediately
+	context.AfterFunc(ctx, stop)
+
+	// subscribe before anything in the background changes books
+	webhooks = newWebhookDispatcher(ctx, cfg.WebhookTimeout, cfg.WebhookAttempts, cfg.WebhookBackoff)
+	audit.subscribe(webhooks.publish)
+	go webhooks.run()
+
+	sseKeepalive = cfg.SSEKeepalive
+	catalogEvents = newEventBroker(cfg.EventBuffer, audit.lastSeq)
+	audit.subscribe(catalogEvents.publish)
+	// streams never end on their own; Shutdown would wait for them
+	srv.RegisterOnShutdown(catalogEvents.close)
+
+	go runTrashPurger(ctx, cfg.PurgeInterval)
+
+	// pick up edits of the JSON file made by other programs; they change
+	// the catalog as far as conditional requests are concerned
+	if js, ok := backend.(*JSONStore); ok {
+		go js.Watch(ctx, cfg.WatchInterval, versioned.touch)
+	}
+
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		// stop the app is any error to start the server
+		log.Fatal(err)
+	}
+	fmt.Printf("App is listening on %v\n", cfg.Addr)
+	if err := serve(ctx, srv, ln, cfg); err != nil {
+		if ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		log.Printf("Error - shutdown: %v", err)
+	}
+	// wait for pending writes and release the back-end
+	if err := store.Close(); err != nil {
+		log.Printf("Error - close store: %v", err)
+	}
+}
+
+// serve answers requests on ln until ctx is done, then stops accepting
+// connections and waits up to cfg.ShutdownTimeout for in-flight requests.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, cfg *Config) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" {
+			serveErr <- srv.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining in-flight requests (up to %v)", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
 }
 
 func handleGetBooks(w http.ResponseWriter, r *http.Request) {
+	query, fieldErrs := parseListQuery(r.URL.Query())
+	if fieldErrs != nil {
+		writeError(w, 400, "Invalid query parameters", fieldErrs...)
+		return
+	}
+	writeBookList(w, r, query)
+}
+
+// writeBookList answers with the page of books selected by query.
+func writeBookList(w http.ResponseWriter, r *http.Request, query listQuery) {
 	books, err := getBooks()
+	if err == nil {
+		books, err = convertPrices(books, query.currency)
+	}
+	var (
+		page  []Book
+		total int
+	)
+	if err == nil {
+		page, total, err = query.apply(books)
+	}
 
 	// send server error as response
-	if err != nil {
-		log.Printf("Server Error %v\n", err)
+	if errors.Is(err, errNoExchangeRate) {
+		writeConversionError(w, r, err)
+	} else if err != nil {
+		logError(r, err)
 		w.WriteHeader(500)
-		w.Write(jsonMessageByte("Internal server error"))
+		w.Write(jsonErrorByte("Internal server error"))
 	} else {
-		booksByte, _ := json.Marshal(books)
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		booksByte, _ := json.Marshal(page)
+		if notModified(w, r, etagOfBytes(booksByte), catalogModTime()) {
+			return
+		}
 		w.Write(booksByte)
 	}
 
@@ -72,21 +290,37 @@ func handleGetBooks(w http.ResponseWriter, r *http.Request) {
 
 func handleGetBookById(w http.ResponseWriter, r *http.Request) {
 
-	query := r.URL.Query()
-	// get book id from URL
-	bookId := query.Get("id")
+	// get book id from the path (/books/{id}) or the query (/book?id=)
+	bookId := r.PathValue("id")
+	if bookId == "" {
+		bookId = r.URL.Query().Get("id")
+	}
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if fieldErrs := checkCurrency(currency); fieldErrs != nil {
+		writeError(w, 400, "Invalid query parameters", fieldErrs...)
+		return
+	}
 	book, err := getBookById(bookId)
+	if err == nil && book.Id != "" {
+		book.Price, err = exchangeRates.convert(book.Price, currency)
+	}
 	// send server error as response
-	if err != nil {
-		log.Printf("Server Error %v\n", err)
+	if errors.Is(err, errNoExchangeRate) {
+		writeConversionError(w, r, err)
+	} else if err != nil {
+		logError(r, err)
 		w.WriteHeader(500)
-		w.Write(jsonMessageByte("Internal server error"))
+		w.Write(jsonErrorByte("Internal server error"))
 	} else {
 		// check requested book exists or not
-		if (Book{}) == book {
-			w.Write(jsonMessageByte("Book Not found"))
+		if book.Id == "" {
+			w.WriteHeader(404)
+			w.Write(jsonErrorByte("Book Not found"))
 		} else {
 			bookByte, _ := json.Marshal(book)
+			if notModified(w, r, etagOfBytes(bookByte), catalogModTime()) {
+				return
+			}
 			w.Write(bookByte)
 		}
 	}
@@ -96,32 +330,292 @@ func handleAddBook(w http.ResponseWriter, r *http.Request) {
 	// check for post method
 	if r.Method != "POST" {
 		w.WriteHeader(405)
-		w.Write(jsonMessageByte(r.Method + " - Method not allowed"))
+		w.Write(jsonErrorByte(r.Method + " - Method not allowed"))
 	} else {
 		// read the body
 		newBookByte, err := io.ReadAll(r.Body)
 		// check for valid data from client
 		if err != nil {
-			log.Printf("Client Error %v\n", err)
-			w.WriteHeader(400)
-			w.Write(jsonMessageByte("Bad Request"))
+			requestLog(r).Info("client error", zap.Error(err))
+			writeBodyError(w, err, "Bad Request")
 		} else {
-			books, _ := getBooks() // get all books
-			var newBooks []Book    // to add new book
+			var newBooks []Book // to add new book
+			if err = json.Unmarshal(newBookByte, &newBooks); err != nil {
+				writeError(w, 400, "Request body must be a JSON array of books")
+				return
+			}
+			if tooManyBooks(w, len(newBooks)) {
+				return
+			}
+			assignMissingIDs(newBooks)
+			if fieldErrs := validateBooks(newBooks); fieldErrs != nil {
+				writeError(w, 400, "Validation failed", fieldErrs...)
+				return
+			}
+
+			mutateMu.Lock()
+			defer mutateMu.Unlock()
 
-			json.Unmarshal(newBookByte, &newBooks)  // new book added
-			books = AppendNewBooks(books, newBooks) // Append new books if they are not already available
-			// Write all the books in books.json file
-			err = saveBooks(books)
+			// check every book before the first one creates an author
+			var fieldErrs []FieldError
+			for i, book := range newBooks {
+				errs, err := checkRelations(book, Book{})
+				if err != nil {
+					logError(r, err)
+					writeError(w, 500, "Internal server error")
+					return
+				}
+				for _, fe := range errs {
+					fe.Field = fmt.Sprintf("[%d].%s", i, fe.Field)
+					fieldErrs = append(fieldErrs, fe)
+				}
+			}
+			if fieldErrs != nil {
+				writeError(w, 400, "Validation failed", fieldErrs...)
+				return
+			}
+			// authors are only created for the books that are saved
+			var linker authorLinker
+			for i := range newBooks {
+				resetServerFields(&newBooks[i])
+				if _, err := linker.link(&newBooks[i], Book{}); err != nil {
+					logError(r, err)
+					writeError(w, 500, "Internal server error")
+					return
+				}
+			}
+
+			// ?allow_similar=true adds books even if title and author match an existing one
+			allowSimilar, _ := strconv.ParseBool(r.URL.Query().Get("allow_similar"))
+
+			books, err := getBooks() // get all books
+			if err != nil {
+				logError(r, err)
+				writeError(w, 500, "Internal server error")
+				return
+			}
+			// Append new books if they are not already available
+			merged, rejected := AppendNewBooks(books, newBooks, allowSimilar)
+			// Persist only the books that were actually new, in one write
+			report := AddReport{Created: []Book{}, Rejected: rejected}
+			for _, book := range merged[len(books):] {
+				_, err = store.Get(book.Id)
+				if err == nil {
+					// the id belongs to a book in the trash
+					report.Rejected = append(report.Rejected, RejectedBook{slices.IndexFunc(newBooks, func(b Book) bool { return b.Id == book.Id }), reasonDuplicateID, book.Id, book})
+					continue
+				}
+				if !errors.Is(err, ErrNotFound) {
+					break
+				}
+				err = nil
+				report.Created = append(report.Created, book)
+			}
+			if err == nil && len(report.Created) > 0 {
+				err = storeAs(actorFromContext(r.Context())).SaveBatch(linker.usedBy(report.Created), report.Created, nil)
+			}
 			// send server error as response
 			if err != nil {
-				log.Printf("Server Error %v\n", err)
+				logError(r, err)
 				w.WriteHeader(500)
-				w.Write(jsonMessageByte("Internal server error"))
+				w.Write(jsonErrorByte("Internal server error"))
 			} else {
-				w.Write(jsonMessageByte("New book added successfully"))
+				if report.Rejected == nil {
+					report.Rejected = []RejectedBook{}
+				}
+				report.Msg = fmt.Sprintf("%d new book(s) added, %d rejected as duplicates", len(report.Created), len(report.Rejected))
+				writeJSON(w, 200, report)
 			}
 
 		}
 	}
 }
+
+// handleCreateBook adds a single book and points the client at it.
+func handleCreateBook(w http.ResponseWriter, r *http.Request) {
+	var book Book
+	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	if book.Id == "" {
+		book.Id = newID()
+	}
+	resetServerFields(&book)
+	if fieldErrs := book.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	fieldErrs, err := linkRelations(&book, Book{})
+	if err == nil && fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+	if err == nil {
+		err = storeAs(actorFromContext(r.Context())).Create(book)
+	}
+	switch {
+	case errors.Is(err, ErrExists):
+		writeError(w, 409, "Book already exists")
+	case err != nil:
+		logError(r, err)
+		writeError(w, 500, "Internal server error")
+	default:
+		w.Header().Set("Location", "/books/"+url.PathEscape(book.Id))
+		w.Header().Set("ETag", etagOf(book))
+		writeJSON(w, 201, book)
+	}
+}
+
+// handleReplaceBook overwrites every field of an existing book.
+func handleReplaceBook(w http.ResponseWriter, r *http.Request) {
+	var book Book
+	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	book.Id = r.PathValue("id")
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	current, err := getLiveBook(book.Id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if preconditionFailed(w, r, current) {
+		return
+	}
+	preserveServerFields(&book, current)
+	updateBook(w, r, book, current)
+}
+
+// handlePatchBook overwrites only the fields present in the request body.
+func handlePatchBook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	// read the body before locking, a slow client must not hold up other writes
+	var patch json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	book, err := getLiveBook(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if preconditionFailed(w, r, book) {
+		return
+	}
+	current := book
+	if err := json.Unmarshal(patch, &book); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	book.Id = id
+	preserveServerFields(&book, current)
+	updateBook(w, r, book, current)
+}
+
+func handleDeleteBook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	current, err := getLiveBook(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if preconditionFailed(w, r, current) {
+		return
+	}
+	// soft delete: the book moves to the trash, see trash.go
+	current.DeletedAt = time.Now().UTC()
+	if err := storeAs(actorFromContext(r.Context())).Update(current); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// resetServerFields clears the fields that only dedicated endpoints may
+// change, so a new book starts without stock, thumbnails or a rating
+// whatever the client sent.
+func resetServerFields(book *Book) {
+	book.Inventory = Inventory{}
+	book.Thumbnails = Thumbnails{}
+	book.Rating = RatingSummary{}
+	book.DeletedAt = time.Time{}
+}
+
+// preserveServerFields copies the fields that only dedicated endpoints may
+// change (e.g. stock, via /books/{id}/inventory, or the rating, via the
+// reviews) from current to book, so PUT and PATCH cannot overwrite them.
+// New books start without any, see resetServerFields.
+func preserveServerFields(book *Book, current Book) {
+	book.Inventory = current.Inventory
+	book.Rating = current.Rating
+	book.DeletedAt = current.DeletedAt
+	// thumbnails belong to the uploaded cover, not to a new image_url
+	if book.Imageurl == current.Imageurl {
+		book.Thumbnails = current.Thumbnails
+	} else {
+		book.Thumbnails = Thumbnails{}
+	}
+}
+
+// updateBook validates book and stores it in place of current. The caller
+// must hold mutateMu.
+func updateBook(w http.ResponseWriter, r *http.Request, book, current Book) {
+	if fieldErrs := book.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+	fieldErrs, err := linkRelations(&book, current)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+	if err := storeAs(actorFromContext(r.Context())).Update(book); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(book))
+	writeJSON(w, 200, book)
+}
+
+// writeStoreError maps a BookStore error to a JSON response.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, 404, "Book Not found")
+		return
+	}
+	logError(r, err)
+	writeError(w, 500, "Internal server error")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string, fieldErrs ...FieldError) {
+	writeJSON(w, status, ErrorResponse{msg, fieldErrs})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
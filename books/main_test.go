@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer serves every route, without authentication, on a JSON
+// store in a temporary directory. It returns the server and the path of
+// the catalog file.
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "books.json")
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store = newVersionedStore(NewJSONStore(path))
+	var err error
+	if audit, err = openAuditLog(filepath.Join(dir, "books.audit.ndjson")); err != nil {
+		t.Fatal(err)
+	}
+	if hookLog, err = openWebhookLog(filepath.Join(dir, "books.webhooks.ndjson")); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.pattern, rt.handler)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+		store.Close()
+		store, audit, hookLog = nil, nil, nil
+	})
+	return srv, path
+}
+
+// post sends body to the test server, fails the test unless the response
+// has the status want and returns the response body.
+func post(t *testing.T, srv *httptest.Server, path, body string, want int) []byte {
+	t.Helper()
+	return send(t, srv, "POST", path, body, want)
+}
+
+// send is post for any method.
+func send(t *testing.T, srv *httptest.Server, method, path, body string, want int) []byte {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	if resp.StatusCode != want {
+		t.Errorf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, want, data)
+	}
+	return data
+}
+
+// fieldsOf returns the fields named by the errors of an ErrorResponse.
+func fieldsOf(t *testing.T, body []byte) []string {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("not an ErrorResponse: %s", body)
+	}
+	fields := []string{}
+	for _, fe := range resp.Errors {
+		fields = append(fields, fe.Field)
+	}
+	return fields
+}
+
+func TestConcurrentAddsAreNotLost(t *testing.T) {
+	srv, path := newTestServer(t)
+
+	const n = 300
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			book := fmt.Sprintf(`{"id":"book-%d","title":"Title %d","author":"Author %d","price":"%d.00"}`, i, i, i, i)
+			if i%2 == 0 {
+				post(t, srv, "/add", "["+book+"]", 200)
+			} else {
+				post(t, srv, "/books", book, 201)
+			}
+		})
+	}
+	wg.Wait()
+
+	c, err := readCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := map[string]bool{}
+	for _, b := range c.Books {
+		saved[b.Id] = true
+	}
+	for i := range n {
+		if id := fmt.Sprintf("book-%d", i); !saved[id] {
+			t.Errorf("%s is missing from %s", id, path)
+		}
+	}
+	if len(c.Books) != n {
+		t.Errorf("%d books saved, want %d", len(c.Books), n)
+	}
+}
+
+func TestAddRejectedBooksCreateNoAuthors(t *testing.T) {
+	srv, path := newTestServer(t)
+	post(t, srv, "/add", `[{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}]`, 200)
+	// a duplicate id and a similar book, each by a new author name, next
+	// to a new book
+	post(t, srv, "/add", `[{"id":"a","title":"Other","author":"Somebody Else","price":"1.00"},
+		{"title":"Dune","author":"FRANK  HERBERT","price":"9.99"},
+		{"id":"b","title":"Emma","author":"Jane Austen","price":"5.00"}]`, 200)
+
+	c, err := readCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Books) != 2 {
+		t.Errorf("%d books saved, want 2", len(c.Books))
+	}
+	var names []string
+	for _, a := range c.Authors {
+		names = append(names, a.Name)
+	}
+	if !slices.Equal(names, []string{"Frank Herbert", "Jane Austen"}) {
+		t.Errorf("authors = %q, want Frank Herbert and Jane Austen", names)
+	}
+}
+
+func TestSlowPatchDoesNotBlockWrites(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+
+	// a PATCH whose body has not arrived yet
+	body, sendBody := io.Pipe()
+	req, err := http.NewRequest("PATCH", srv.URL+"/books/a", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := make(chan int)
+	go func() {
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Error(err)
+			close(patched)
+			return
+		}
+		resp.Body.Close()
+		patched <- resp.StatusCode
+	}()
+	sendBody.Write([]byte(`{"title":`))
+
+	created := make(chan struct{})
+	go func() {
+		post(t, srv, "/books", `{"id":"b","title":"Emma","author":"Jane Austen","price":"5.00"}`, 201)
+		close(created)
+	}()
+	select {
+	case <-created:
+	case <-time.After(5 * time.Second):
+		t.Error("POST /books waited for the body of a PATCH")
+		sendBody.Close()
+		<-created
+		return
+	}
+
+	sendBody.Write([]byte(`"Dune Messiah"}`))
+	sendBody.Close()
+	if status := <-patched; status != 200 {
+		t.Errorf("PATCH: status %d, want 200", status)
+	}
+}
+
+func TestCreateIgnoresServerFields(t *testing.T) {
+	srv, _ := newTestServer(t)
+	server := `"inventory":{"on_hand":1000},"rating":{"average":5,"count":99},"thumbnails":{"small":"/covers/x.jpg"},"deleted_at":"2020-01-01T00:00:00Z"`
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99",`+server+`}`, 201)
+	post(t, srv, "/add", `[{"id":"b","title":"Emma","author":"Jane Austen","price":"4.99",`+server+`}]`, 200)
+	post(t, srv, "/books/import", `[{"id":"c","title":"Ulysses","author":"James Joyce","price":"7.99",`+server+`}]`, 200)
+
+	for _, id := range []string{"a", "b", "c"} {
+		var book Book
+		if err := json.Unmarshal(send(t, srv, "GET", "/books/"+id, "", 200), &book); err != nil {
+			t.Fatal(err)
+		}
+		if book.Inventory != (Inventory{}) || book.Rating != (RatingSummary{}) || book.Thumbnails != (Thumbnails{}) || !book.DeletedAt.IsZero() {
+			t.Errorf("book %s = %+v, want no stock, rating, thumbnails or deletion", id, book)
+		}
+	}
+	// stock only arrives through the inventory endpoint
+	post(t, srv, "/books/a/inventory/adjust", `{"delta":3}`, 200)
+	if book, _ := store.Get("a"); book.Inventory.OnHand != 3 {
+		t.Errorf("on hand = %d, want 3", book.Inventory.OnHand)
+	}
+}
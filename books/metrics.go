@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds (in seconds) of the request latency
+// histogram, the same defaults the Prometheus client libraries use.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// serverMetrics collects request counts and latencies and renders them in
+// the Prometheus text exposition format.
+type serverMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestLabels]uint64
+	latencies map[routeLabels]*histogram
+}
+
+type routeLabels struct {
+	method, route string
+}
+
+type requestLabels struct {
+	routeLabels
+	status int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// metrics is the process-wide collector fed by the request middleware.
+var metrics = newServerMetrics()
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:  make(map[requestLabels]uint64),
+		latencies: make(map[routeLabels]*histogram),
+	}
+}
+
+// observe records one finished request. route is the ServeMux pattern that
+// served it, which keeps the label cardinality bounded.
+func (m *serverMetrics) observe(method, route string, status int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rl := routeLabels{method, route}
+	m.requests[requestLabels{rl, status}]++
+
+	h, ok := m.latencies[rl]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.latencies[rl] = h
+	}
+	secs := elapsed.Seconds()
+	if i, _ := slices.BinarySearch(durationBuckets, secs); i < len(durationBuckets) {
+		h.counts[i]++
+	}
+	h.sum += secs
+	h.count++
+}
+
+// handleMetrics exposes the collected metrics for Prometheus to scrape.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metrics.writeTo(&b)
+
+	b.WriteString("# HELP books_catalog_size Number of books in the catalog.\n")
+	b.WriteString("# TYPE books_catalog_size gauge\n")
+	if books, err := getBooks(); err == nil {
+		fmt.Fprintf(&b, "books_catalog_size %d\n", len(books))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func (m *serverMetrics) writeTo(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b.WriteString("# HELP books_http_requests_total Number of HTTP requests by method, route and status.\n")
+	b.WriteString("# TYPE books_http_requests_total counter\n")
+	reqKeys := make([]requestLabels, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	slices.SortFunc(reqKeys, func(a, b requestLabels) int {
+		if c := compareRoutes(a.routeLabels, b.routeLabels); c != 0 {
+			return c
+		}
+		return a.status - b.status
+	})
+	for _, k := range reqKeys {
+		fmt.Fprintf(b, "books_http_requests_total{method=%q,route=%q,status=\"%d\"} %d\n",
+			k.method, k.route, k.status, m.requests[k])
+	}
+
+	b.WriteString("# HELP books_http_request_duration_seconds Latency of HTTP requests by method and route.\n")
+	b.WriteString("# TYPE books_http_request_duration_seconds histogram\n")
+	latKeys := make([]routeLabels, 0, len(m.latencies))
+	for k := range m.latencies {
+		latKeys = append(latKeys, k)
+	}
+	slices.SortFunc(latKeys, compareRoutes)
+	for _, k := range latKeys {
+		h := m.latencies[k]
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "books_http_request_duration_seconds_bucket{method=%q,route=%q,le=%q} %d\n",
+				k.method, k.route, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(b, "books_http_request_duration_seconds_bucket{method=%q,route=%q,le=\"+Inf\"} %d\n", k.method, k.route, h.count)
+		fmt.Fprintf(b, "books_http_request_duration_seconds_sum{method=%q,route=%q} %g\n", k.method, k.route, h.sum)
+		fmt.Fprintf(b, "books_http_request_duration_seconds_count{method=%q,route=%q} %d\n", k.method, k.route, h.count)
+	}
+}
+
+func compareRoutes(a, b routeLabels) int {
+	if c := strings.Compare(a.route, b.route); c != 0 {
+		return c
+	}
+	return strings.Compare(a.method, b.method)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errMixedCurrencies is returned when amounts in different currencies are
+// added up.
+var errMixedCurrencies = errors.New("prices are in different currencies")
+
+// defaultCurrency is assumed for prices stored without a currency, such
+// as the legacy "600". Set in main.
+var defaultCurrency = "USD"
+
+// Money is an amount in minor units (cents) and an ISO 4217 currency
+// code. Integer arithmetic keeps totals exact. The zero Money means "no
+// price".
+type Money struct {
+	Amount   int64
+	Currency string
+
+	invalid string // unparsable input as JSON, reported by Book.Validate and written back unchanged
+}
+
+// moneyJSON is the wire form of Money, e.g.
+// {"amount":"12.50","currency":"EUR"}. The amount is written as a decimal
+// string so clients do not round it through a float; numbers are accepted
+// on input.
+type moneyJSON struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+// parsePrice parses a price such as "600" or "12.50 EUR".
+func parsePrice(price string) (Money, error) {
+	if !priceRe.MatchString(price) {
+		return Money{}, fmt.Errorf("invalid price %q", price)
+	}
+	amount, currency, _ := strings.Cut(price, " ")
+	whole, frac, _ := strings.Cut(amount, ".")
+	frac = (frac + "00")[:2]
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid price %q: %w", price, err)
+	}
+	return Money{Amount: units, Currency: currency}, nil
+}
+
+// priceFromString parses price like parsePrice and fills in
+// defaultCurrency. Invalid input is kept for Book.Validate to report.
+func priceFromString(price string) Money {
+	if price == "" {
+		return Money{}
+	}
+	m, err := parsePrice(price)
+	if err != nil {
+		quoted, _ := json.Marshal(price)
+		return Money{invalid: string(quoted)}
+	}
+	if m.Currency == "" {
+		m.Currency = defaultCurrency
+	}
+	return m
+}
+
+// IsZero reports whether m is unset.
+func (m Money) IsZero() bool {
+	return m == Money{}
+}
+
+// decimal formats the amount with two decimals, e.g. "12.50".
+func (m Money) decimal() string {
+	return fmt.Sprintf("%d.%02d", m.Amount/100, m.Amount%100)
+}
+
+// String formats m like the legacy Book.Price, e.g. "12.50 EUR", or
+// returns the input m could not be parsed from.
+func (m Money) String() string {
+	if m.invalid != "" {
+		var s string
+		if json.Unmarshal([]byte(m.invalid), &s) == nil {
+			return s
+		}
+		return m.invalid
+	}
+	s := m.decimal()
+	if m.Currency != "" {
+		s += " " + m.Currency
+	}
+	return s
+}
+
+// MarshalJSON writes the object form, or the input m could not be parsed
+// from, so a stored price that does not parse is not replaced by 0.00.
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.invalid != "" {
+		return []byte(m.invalid), nil
+	}
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.decimal(), m.Currency})
+}
+
+// UnmarshalJSON accepts the object form as well as the legacy string form
+// ("600" or "12.50 EUR") that books were stored with before, and a bare
+// number in the default currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = Money{}
+		return nil
+	}
+	var legacy string
+	if json.Unmarshal(data, &legacy) == nil {
+		if *m = priceFromString(legacy); m.invalid != "" {
+			m.invalid = string(data)
+		}
+		return nil
+	}
+	var number json.Number
+	if json.Unmarshal(data, &number) == nil {
+		if *m = priceFromString(number.String()); m.invalid != "" {
+			m.invalid = string(data)
+		}
+		return nil
+	}
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		*m = Money{invalid: string(data)}
+		return nil
+	}
+	price := v.Amount.String()
+	if v.Currency != "" {
+		price += " " + v.Currency
+	}
+	if *m = priceFromString(price); m.IsZero() || m.invalid != "" {
+		*m = Money{invalid: string(data)}
+	}
+	return nil
+}
+
+// Times returns m multiplied by qty.
+func (m Money) Times(qty int) Money {
+	return Money{Amount: m.Amount * int64(qty), Currency: m.Currency}
+}
+
+// Plus adds o to m. Both must be in the same currency.
+func (m Money) Plus(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %q and %q", errMixedCurrencies, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
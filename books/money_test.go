@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// useExchangeRates replaces the exchange-rate table until the test ends.
+func useExchangeRates(t *testing.T, x ExchangeRates) {
+	t.Helper()
+	saved := exchangeRates
+	exchangeRates = x
+	t.Cleanup(func() { exchangeRates = saved })
+}
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		price   string
+		want    Money
+		wantErr bool
+	}{
+		{"600", Money{Amount: 60000}, false},
+		{"12.5 EUR", Money{Amount: 1250, Currency: "EUR"}, false},
+		{"0.99", Money{Amount: 99}, false},
+		{"12.345", Money{}, true},
+		{"12,50", Money{}, true},
+		{"12.50 euro", Money{}, true},
+		{"99999999999999999999", Money{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePrice(tt.price)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parsePrice(%q) = %+v, %v; want %+v, error %t", tt.price, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPriceFromString(t *testing.T) {
+	tests := []struct {
+		price   string
+		want    Money
+		invalid bool
+	}{
+		{"", Money{}, false},
+		{"9.99", Money{Amount: 999, Currency: "USD"}, false},
+		{"9.99 GBP", Money{Amount: 999, Currency: "GBP"}, false},
+		{"free", Money{}, true},
+	}
+	for _, tt := range tests {
+		got := priceFromString(tt.price)
+		if invalid := got.invalid != ""; invalid != tt.invalid || (!invalid && got != tt.want) {
+			t.Errorf("priceFromString(%q) = %+v, want %+v (invalid %t)", tt.price, got, tt.want, tt.invalid)
+		}
+		if tt.invalid && got.String() != tt.price {
+			t.Errorf("priceFromString(%q).String() = %q, want the input", tt.price, got.String())
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money  // for valid input
+		out  string // written back
+	}{
+		{`{"amount":"12.50","currency":"EUR"}`, Money{Amount: 1250, Currency: "EUR"}, `{"amount":"12.50","currency":"EUR"}`},
+		{`{"amount":7,"currency":"GBP"}`, Money{Amount: 700, Currency: "GBP"}, `{"amount":"7.00","currency":"GBP"}`},
+		{`{"amount":"3"}`, Money{Amount: 300, Currency: "USD"}, `{"amount":"3.00","currency":"USD"}`},
+		{`"600"`, Money{Amount: 60000, Currency: "USD"}, `{"amount":"600.00","currency":"USD"}`},
+		{`"12.50 EUR"`, Money{Amount: 1250, Currency: "EUR"}, `{"amount":"12.50","currency":"EUR"}`},
+		{`4.5`, Money{Amount: 450, Currency: "USD"}, `{"amount":"4.50","currency":"USD"}`},
+		{`null`, Money{}, `{"amount":"0.00","currency":""}`},
+
+		// kept as they were, for Book.Validate to report
+		{`"six hundred"`, Money{}, `"six hundred"`},
+		{`12.345`, Money{}, `12.345`},
+		{`{"amount":"x","currency":"EUR"}`, Money{}, `{"amount":"x","currency":"EUR"}`},
+		{`{"amount":"1","currency":"euro"}`, Money{}, `{"amount":"1","currency":"euro"}`},
+		{`true`, Money{}, `true`},
+	}
+	for _, tt := range tests {
+		var m Money
+		if err := json.Unmarshal([]byte(tt.in), &m); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.in, err)
+			continue
+		}
+		if m.invalid == "" && m != tt.want {
+			t.Errorf("unmarshal %s = %+v, want %+v", tt.in, m, tt.want)
+		}
+		out, err := json.Marshal(m)
+		if err != nil || string(out) != tt.out {
+			t.Errorf("marshal %s = %s, %v; want %s", tt.in, out, err, tt.out)
+		}
+	}
+}
+
+func TestStoredInvalidPriceIsKept(t *testing.T) {
+	var b Book
+	if err := json.Unmarshal([]byte(`{"id":"a","title":"Dune","author":"Frank Herbert","price":"about ten"}`), &b); err != nil {
+		t.Fatal(err)
+	}
+	if errs := b.Validate(); len(errs) != 1 || errs[0].Field != "price" {
+		t.Errorf("Validate = %v, want a price error", errs)
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Book
+	if err := json.Unmarshal(data, &again); err != nil || again.Price.String() != "about ten" {
+		t.Errorf("written back as %s", data)
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	eur := func(cents int64) Money { return Money{Amount: cents, Currency: "EUR"} }
+	if got := eur(250).Times(3); got != eur(750) {
+		t.Errorf("Times = %+v, want 7.50 EUR", got)
+	}
+	if got, err := eur(250).Plus(eur(101)); err != nil || got != eur(351) {
+		t.Errorf("Plus = %+v, %v; want 3.51 EUR", got, err)
+	}
+	if _, err := eur(250).Plus(Money{Amount: 100, Currency: "USD"}); !errors.Is(err, errMixedCurrencies) {
+		t.Errorf("Plus across currencies: %v, want errMixedCurrencies", err)
+	}
+	if s := eur(5).String(); s != "0.05 EUR" {
+		t.Errorf("String = %q, want 0.05 EUR", s)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	x := ExchangeRates{Base: "USD", Rates: map[string]float64{"EUR": 0.92, "JPY": 150}}
+	tests := []struct {
+		m       Money
+		to      string
+		want    Money
+		wantErr bool
+	}{
+		{Money{Amount: 1000, Currency: "USD"}, "EUR", Money{Amount: 920, Currency: "EUR"}, false},
+		{Money{Amount: 920, Currency: "EUR"}, "USD", Money{Amount: 1000, Currency: "USD"}, false},
+		{Money{Amount: 999, Currency: "EUR"}, "JPY", Money{Amount: 162880, Currency: "JPY"}, false},
+		{Money{Amount: 999, Currency: "EUR"}, "EUR", Money{Amount: 999, Currency: "EUR"}, false},
+		{Money{Amount: 999, Currency: "EUR"}, "", Money{Amount: 999, Currency: "EUR"}, false},
+		{Money{}, "GBP", Money{}, false},
+		{Money{Amount: 999, Currency: "GBP"}, "USD", Money{}, true},
+		{Money{Amount: 999, Currency: "USD"}, "GBP", Money{}, true},
+	}
+	for _, tt := range tests {
+		got, err := x.convert(tt.m, tt.to)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("convert(%v, %q) = %+v, %v; want %+v, error %t", tt.m, tt.to, got, err, tt.want, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, errNoExchangeRate) {
+			t.Errorf("convert(%v, %q): %v, want errNoExchangeRate", tt.m, tt.to, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// openAPISpec is the OpenAPI 3 contract of the server, served verbatim.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// checkOpenAPISpec reports routes that are missing from openapi.json and
+// operations in openapi.json that no route serves. A pattern without a
+// method (e.g. "/add") covers every operation documented on its path.
+func checkOpenAPISpec(routes []route) error {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return fmt.Errorf("parse openapi.json: %w", err)
+	}
+
+	served := make(map[string][]string) // path -> methods ("" = any)
+	var problems []string
+	for _, rt := range routes {
+		method, path, ok := strings.Cut(rt.pattern, " ")
+		if !ok {
+			method, path = "", rt.pattern
+		}
+		served[path] = append(served[path], strings.ToLower(method))
+
+		ops, documented := spec.Paths[path]
+		if _, hasOp := ops[strings.ToLower(method)]; !documented || (method != "" && !hasOp) {
+			problems = append(problems, "route "+rt.pattern+" is not documented")
+		}
+	}
+
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if method == "parameters" {
+				continue
+			}
+			if m := served[path]; !slices.Contains(m, method) && !slices.Contains(m, "") {
+				problems = append(problems, "operation "+strings.ToUpper(method)+" "+path+" has no route")
+			}
+		}
+	}
+
+	if problems != nil {
+		slices.Sort(problems)
+		return fmt.Errorf("openapi.json is out of sync with the routes:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
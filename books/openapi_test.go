@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	if err := checkOpenAPISpec(routes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPISpecReportsDrift(t *testing.T) {
+	extra := append(routes[:len(routes):len(routes)], route{"GET /undocumented", handleGetBooks})
+	err := checkOpenAPISpec(extra)
+	if err == nil || !strings.Contains(err.Error(), "route GET /undocumented is not documented") {
+		t.Fatalf("checkOpenAPISpec with an undocumented route = %v", err)
+	}
+}
+
+func TestOpenAPISpecIsValid(t *testing.T) {
+	var spec struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want 3.x", spec.OpenAPI)
+	}
+	for _, name := range []string{"Book", "Money", "Message", "ErrorResponse", "FieldError", "Author", "Category", "Review", "Webhook", "Delivery"} {
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+
+	// every local $ref must point at something
+	var doc any
+	json.Unmarshal(openAPISpec, &doc)
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok && resolveRef(doc, ref) == nil {
+				t.Errorf("$ref %s does not resolve", ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// resolveRef returns the value a "#/a/b" reference points at in doc, or
+// nil.
+func resolveRef(doc any, ref string) any {
+	path, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil
+	}
+	v := doc
+	for _, key := range strings.Split(path, "/") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[strings.NewReplacer("~1", "/", "~0", "~").Replace(key)]
+	}
+	return v
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoExchangeRate is returned when a price cannot be converted because
+// the exchange-rate table lacks one of the currencies.
+var errNoExchangeRate = errors.New("no exchange rate")
+
+// ExchangeRates is the locally configured exchange-rate table: how many
+// units of each currency one unit of Base buys, e.g.
+//
+//	{"base": "USD", "rates": {"EUR": 0.92, "GBP": 0.79}}
+type ExchangeRates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// exchangeRates is loaded in main; without a table only prices that are
+// already in the requested currency can be returned.
+var exchangeRates = ExchangeRates{Base: defaultCurrency, Rates: map[string]float64{}}
+
+// loadExchangeRates reads the table at path.
+func loadExchangeRates(path string) (ExchangeRates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ExchangeRates{}, err
+	}
+	var x ExchangeRates
+	if err := json.Unmarshal(data, &x); err != nil {
+		return ExchangeRates{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if !currencyRe.MatchString(x.Base) {
+		return ExchangeRates{}, fmt.Errorf("%s: base must be an ISO 4217 code", path)
+	}
+	for currency, rate := range x.Rates {
+		if !currencyRe.MatchString(currency) || rate <= 0 {
+			return ExchangeRates{}, fmt.Errorf("%s: invalid rate for %q", path, currency)
+		}
+	}
+	return x, nil
+}
+
+// rate returns the units of currency per unit of the base currency.
+func (x ExchangeRates) rate(currency string) (float64, bool) {
+	if currency == x.Base {
+		return 1, true
+	}
+	r, ok := x.Rates[currency]
+	return r, ok
+}
+
+// convert returns m in the currency to, rounded to the nearest cent.
+func (x ExchangeRates) convert(m Money, to string) (Money, error) {
+	if m.IsZero() || to == "" || m.Currency == to {
+		return m, nil
+	}
+	from, ok := x.rate(m.Currency)
+	dst, ok2 := x.rate(to)
+	if !ok || !ok2 {
+		return Money{}, fmt.Errorf("%w from %s to %s", errNoExchangeRate, m.Currency, to)
+	}
+	return Money{Amount: int64(math.Round(float64(m.Amount) / from * dst)), Currency: to}, nil
+}
+
+// convertPrices returns books with their prices in currency; an empty
+// currency leaves them as they are.
+func convertPrices(books []Book, currency string) ([]Book, error) {
+	if currency == "" {
+		return books, nil
+	}
+	converted := make([]Book, len(books))
+	for i, b := range books {
+		price, err := exchangeRates.convert(b.Price, currency)
+		if err != nil {
+			return nil, fmt.Errorf("book %s: %w", b.Id, err)
+		}
+		b.Price = price
+		converted[i] = b
+	}
+	return converted, nil
+}
+
+// checkCurrency reports a FieldError if prices cannot be converted into
+// the requested currency.
+func checkCurrency(currency string) []FieldError {
+	if currency == "" {
+		return nil
+	}
+	if _, ok := exchangeRates.rate(currency); !ok {
+		return []FieldError{{"currency", "no exchange rate for " + strconv.Quote(currency)}}
+	}
+	return nil
+}
+
+// writeConversionError answers a failed price conversion.
+func writeConversionError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNoExchangeRate) {
+		writeError(w, 422, "Cannot convert prices: "+err.Error())
+		return
+	}
+	writeStoreError(w, r, err)
+}
+
+// PricePoint is a price a book had from Time on, as recorded by the
+// audit log.
+type PricePoint struct {
+	Seq   int64     `json:"seq"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Price Money     `json:"price,omitzero"` // absent while the book had no price
+}
+
+// priceHistory extracts the price changes of a book from its audit
+// events, oldest first.
+func priceHistory(events []AuditEvent) []PricePoint {
+	points := []PricePoint{}
+	for _, ev := range events {
+		if ev.After == nil || (ev.Before != nil && ev.Before.Price == ev.After.Price) {
+			continue
+		}
+		points = append(points, PricePoint{ev.Seq, ev.Time, ev.Actor, ev.After.Price})
+	}
+	return points
+}
+
+// handleBookPrices lists the price changes of a book, optionally
+// converted with ?currency= at today's exchange rates.
+func handleBookPrices(w http.ResponseWriter, r *http.Request) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if fieldErrs := checkCurrency(currency); fieldErrs != nil {
+		writeError(w, 400, "Invalid query parameters", fieldErrs...)
+		return
+	}
+	events, err := audit.history(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if len(events) == 0 {
+		writeError(w, 404, "No history for this book")
+		return
+	}
+	points := priceHistory(events)
+	for i := range points {
+		if points[i].Price, err = exchangeRates.convert(points[i].Price, currency); err != nil {
+			writeConversionError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, 200, points)
+}
+
+// handleExchangeRates lists the configured exchange-rate table.
+func handleExchangeRates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, exchangeRates)
+}
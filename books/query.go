@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// listQuery holds the search, filter, sort and pagination parameters of
+// the book listing endpoint, e.g.
+//
+//	/books?q=habits&author=james+clear&min_price=100&max_price=500&sort=-price&limit=20&offset=40
+type listQuery struct {
+	search     string   // q: case-insensitive substring of title or author
+	author     string   // author: case-insensitive exact match
+	authorId   string   // author_id: exact match, also set by /authors/{id}/books
+	categoryId string   // category_id: one of the book's categories
+	minPrice   *float64 // min_price: inclusive lower bound, see prices
+	maxPrice   *float64 // max_price: inclusive upper bound
+	minRating  *float64 // min_rating: inclusive lower bound of the average rating
+	currency   string   // currency: prices are converted before filtering and sorting
+	sortBy     string   // sort: field name, prefixed with "-" for descending
+	desc       bool
+	limit      int // limit: 0 means no limit
+	offset     int // offset: number of matching books to skip
+
+	// prices by book id in currency, or without one in defaultCurrency,
+	// so price filters and sorting compare like with like; set by apply
+	prices map[string]float64
+}
+
+// bookSortKeys maps the sortable JSON field names to a comparison function.
+var bookSortKeys = map[string]func(a, b Book) int{
+	"id":        func(a, b Book) int { return cmp.Compare(a.Id, b.Id) },
+	"title":     func(a, b Book) int { return cmp.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+	"author":    func(a, b Book) int { return cmp.Compare(strings.ToLower(a.Author), strings.ToLower(b.Author)) },
+	"price":     nil, // compared in one currency, see listQuery.apply
+	"rating":    func(a, b Book) int { return cmp.Compare(a.Rating.Average, b.Rating.Average) },
+	"image_url": func(a, b Book) int { return cmp.Compare(a.Imageurl, b.Imageurl) },
+}
+
+// parseListQuery reads the listing parameters from the URL query and
+// reports every malformed one.
+func parseListQuery(v url.Values) (listQuery, []FieldError) {
+	var (
+		q    listQuery
+		errs []FieldError
+	)
+	q.search = strings.ToLower(strings.TrimSpace(v.Get("q")))
+	q.author = strings.TrimSpace(v.Get("author"))
+	q.authorId = strings.TrimSpace(v.Get("author_id"))
+	q.categoryId = strings.TrimSpace(v.Get("category_id"))
+	q.currency = strings.ToUpper(strings.TrimSpace(v.Get("currency")))
+	errs = append(errs, checkCurrency(q.currency)...)
+
+	for _, p := range []struct {
+		name string
+		dst  **float64
+	}{{"min_price", &q.minPrice}, {"max_price", &q.maxPrice}, {"min_rating", &q.minRating}} {
+		if raw := v.Get(p.name); raw != "" {
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				errs = append(errs, FieldError{p.name, "must be a number"})
+				continue
+			}
+			*p.dst = &f
+		}
+	}
+
+	if s := v.Get("sort"); s != "" {
+		q.desc = strings.HasPrefix(s, "-")
+		q.sortBy = strings.TrimPrefix(s, "-")
+		if _, ok := bookSortKeys[q.sortBy]; !ok {
+			errs = append(errs, FieldError{"sort", "unknown field " + strconv.Quote(q.sortBy)})
+		}
+	}
+
+	var pageErrs []FieldError
+	q.limit, q.offset, pageErrs = parsePage(v)
+	return q, append(errs, pageErrs...)
+}
+
+// parsePage reads the limit and offset parameters shared by the listing
+// endpoints.
+func parsePage(v url.Values) (limit, offset int, errs []FieldError) {
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &limit}, {"offset", &offset}} {
+		if raw := v.Get(p.name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				errs = append(errs, FieldError{p.name, "must be a non-negative integer"})
+				continue
+			}
+			*p.dst = n
+		}
+	}
+	return limit, offset, errs
+}
+
+// page returns the items selected by limit (0 means no limit) and offset.
+func page[T any](items []T, limit, offset int) []T {
+	start := min(offset, len(items))
+	end := len(items)
+	if limit > 0 {
+		end = min(start+limit, len(items))
+	}
+	return items[start:end]
+}
+
+// apply filters and sorts books, and returns the requested page together
+// with the number of books that matched before pagination. It fails with
+// errNoExchangeRate if prices have to be compared and one of them cannot
+// be converted.
+func (q listQuery) apply(books []Book) ([]Book, int, error) {
+	if q.minPrice != nil || q.maxPrice != nil || q.sortBy == "price" {
+		q.prices = make(map[string]float64, len(books))
+		for _, b := range books {
+			if b.Price.invalid != "" {
+				continue // compared as no price, Book.Validate reports it
+			}
+			price, err := exchangeRates.convert(b.Price, cmp.Or(q.currency, defaultCurrency))
+			if err != nil {
+				return nil, 0, fmt.Errorf("book %s: %w", b.Id, err)
+			}
+			q.prices[b.Id] = float64(price.Amount) / 100
+		}
+	}
+
+	matched := make([]Book, 0, len(books))
+	for _, b := range books {
+		if q.matches(b) {
+			matched = append(matched, b)
+		}
+	}
+
+	if q.sortBy != "" {
+		compare := bookSortKeys[q.sortBy]
+		if q.sortBy == "price" {
+			compare = func(a, b Book) int { return cmp.Compare(q.prices[a.Id], q.prices[b.Id]) }
+		}
+		slices.SortStableFunc(matched, func(a, b Book) int {
+			if q.desc {
+				return compare(b, a)
+			}
+			return compare(a, b)
+		})
+	}
+
+	return page(matched, q.limit, q.offset), len(matched), nil
+}
+
+func (q listQuery) matches(b Book) bool {
+	if q.search != "" &&
+		!strings.Contains(strings.ToLower(b.Title), q.search) &&
+		!strings.Contains(strings.ToLower(b.Author), q.search) {
+		return false
+	}
+	if q.author != "" && !strings.EqualFold(b.Author, q.author) {
+		return false
+	}
+	if q.authorId != "" && b.AuthorId != q.authorId {
+		return false
+	}
+	if q.categoryId != "" && !slices.Contains(b.CategoryIds, q.categoryId) {
+		return false
+	}
+	if q.minPrice != nil && q.prices[b.Id] < *q.minPrice {
+		return false
+	}
+	if q.maxPrice != nil && q.prices[b.Id] > *q.maxPrice {
+		return false
+	}
+	if q.minRating != nil && b.Rating.Average < *q.minRating {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestParseListQueryErrors(t *testing.T) {
+	v := url.Values{
+		"min_price": {"cheap"},
+		"sort":      {"-publisher"},
+		"limit":     {"-1"},
+		"offset":    {"x"},
+		"currency":  {"euro"},
+	}
+	_, errs := parseListQuery(v)
+	var got []string
+	for _, fe := range errs {
+		got = append(got, fe.Field)
+	}
+	slices.Sort(got)
+	if want := []string{"currency", "limit", "min_price", "offset", "sort"}; !slices.Equal(got, want) {
+		t.Errorf("fields %q, want %q", got, want)
+	}
+}
+
+func TestListQueryApply(t *testing.T) {
+	usd := func(cents int64) Money { return Money{Amount: cents, Currency: "USD"} }
+	books := []Book{
+		{Id: "1", Title: "Atomic Habits", Author: "James Clear", Price: usd(1500), CategoryIds: []string{"self-help"}, Rating: RatingSummary{4.5, 2}},
+		{Id: "2", Title: "Dune", Author: "Frank Herbert", Price: usd(999), CategoryIds: []string{"sf"}},
+		{Id: "3", Title: "Children of Dune", Author: "Frank Herbert", Price: usd(1299), CategoryIds: []string{"sf"}, Rating: RatingSummary{3, 1}},
+		{Id: "4", Title: "Emma", Author: "Jane Austen", Price: usd(500)},
+	}
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"", []string{"1", "2", "3", "4"}, 4},
+		{"q=DUNE", []string{"2", "3"}, 2},
+		{"q=herbert", []string{"2", "3"}, 2},
+		{"author=jane+austen", []string{"4"}, 1},
+		{"category_id=sf", []string{"2", "3"}, 2},
+		{"min_price=9.99&max_price=13", []string{"2", "3"}, 2},
+		{"min_rating=4", []string{"1"}, 1},
+		{"sort=price", []string{"4", "2", "3", "1"}, 4},
+		{"sort=-title", []string{"4", "2", "3", "1"}, 4},
+		{"sort=title&limit=2", []string{"1", "3"}, 4},
+		{"sort=title&limit=2&offset=3", []string{"4"}, 4},
+		{"offset=10", []string{}, 4},
+	}
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		q, errs := parseListQuery(v)
+		if errs != nil {
+			t.Fatalf("%s: %v", tt.query, errs)
+		}
+		page, total, err := q.apply(books)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		got := []string{}
+		for _, b := range page {
+			got = append(got, b.Id)
+		}
+		if !slices.Equal(got, tt.want) || total != tt.total {
+			t.Errorf("%s: %q of %d, want %q of %d", tt.query, got, total, tt.want, tt.total)
+		}
+	}
+}
+
+func TestListBooksTotalCount(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/add", `[{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"},
+		{"id":"b","title":"Emma","author":"Jane Austen","price":"5.00"}]`, 200)
+
+	resp, err := srv.Client().Get(srv.URL + "/books?sort=-price&limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var books []Book
+	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].Id != "a" || resp.Header.Get("X-Total-Count") != "2" {
+		t.Errorf("got %d books (first %+v), X-Total-Count %s", len(books), books, resp.Header.Get("X-Total-Count"))
+	}
+	send(t, srv, "GET", "/books?limit=many", "", 400)
+}
+
+func TestListQueryComparesPricesInOneCurrency(t *testing.T) {
+	useExchangeRates(t, ExchangeRates{Base: "USD", Rates: map[string]float64{"EUR": 0.5}})
+	books := []Book{
+		{Id: "usd", Price: Money{Amount: 1500, Currency: "USD"}},
+		{Id: "eur", Price: Money{Amount: 1000, Currency: "EUR"}}, // 20.00 USD
+		{Id: "none"},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"sort=price", []string{"none", "usd", "eur"}},
+		{"min_price=16", []string{"eur"}},
+		{"max_price=16&min_price=1", []string{"usd"}},
+		{"currency=EUR&sort=-price", []string{"eur", "usd", "none"}},
+		{"currency=EUR&max_price=8", []string{"usd", "none"}},
+	}
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		q, errs := parseListQuery(v)
+		if errs != nil {
+			t.Fatalf("%s: %v", tt.query, errs)
+		}
+		page, _, err := q.apply(books)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		got := []string{}
+		for _, b := range page {
+			got = append(got, b.Id)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListBooksWithoutExchangeRate(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/add", `[{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"},
+		{"id":"b","title":"Emma","author":"Jane Austen","price":"5.00 GBP"}]`, 200)
+
+	send(t, srv, "GET", "/books", "", 200)
+	send(t, srv, "GET", "/books?sort=price", "", 422)
+	send(t, srv, "GET", "/books?min_price=1", "", 422)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"books/logger"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// baseLogger logs everything not tied to a request and is the fallback
+// for requests without a logger in their context. Set in main.
+var baseLogger = zap.NewNop()
+
+// requestLog returns the logger of r, which carries its request ID.
+func requestLog(r *http.Request) *zap.Logger {
+	return logger.FromContext(r.Context(), baseLogger)
+}
+
+// logError logs an unexpected error while serving r.
+func logError(r *http.Request, err error) {
+	requestLog(r).Error("server error", zap.Error(err))
+}
+
+// requestLogger assigns every request an ID (honoring an incoming
+// X-Request-ID), stores a request-scoped logger in its context, records
+// metrics and logs one line per request once it has been served.
+func requestLogger(base *zap.Logger, mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		reqID := r.Header.Get("X-Request-ID")
+		if reqID == "" {
+			reqID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", reqID)
+
+		l := logger.WithRequestID(logger.FromContext(r.Context(), base), reqID)
+		r = r.WithContext(logger.WithContext(r.Context(), l))
+
+		rec := &statusRecorder{ResponseWriter: w, status: 200}
+		next.ServeHTTP(rec, r)
+
+		elapsed := time.Since(start)
+		// label by the pattern's path, e.g. "/books/{id}" rather than the URL
+		_, route := mux.Handler(r)
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.observe(r.Method, route, rec.status, elapsed)
+
+		l.Info("request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("route", route),
+			zap.Int("status", rec.status),
+			zap.Int("bytes", rec.bytes),
+			zap.Float64("latency_ms", float64(elapsed.Microseconds())/1000),
+			zap.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to flush streaming responses.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
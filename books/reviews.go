@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Review is a customer's rating of a book. Hidden reviews are kept for
+// the moderators but left out of listings and the book's rating.
+type Review struct {
+	Id        string       `json:"id"`
+	BookId    string       `json:"book_id"`
+	Reviewer  string       `json:"reviewer"` // subject of the credential the review was posted with
+	Rating    int          `json:"rating"`   // 1 to 5
+	Text      string       `json:"text,omitempty"`
+	Hidden    bool         `json:"hidden,omitempty"`
+	Flags     []ReviewFlag `json:"flags,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// ReviewFlag is a report of an abusive review.
+type ReviewFlag struct {
+	Reporter string    `json:"reporter"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// RatingSummary is the average rating of a book's visible reviews. It is
+// kept up to date on the book whenever a review changes.
+type RatingSummary struct {
+	Average float64 `json:"average"` // rounded to two decimals
+	Count   int     `json:"count"`
+}
+
+// maxReviewText is the longest accepted review text in characters.
+const maxReviewText = 5000
+
+// autoHideFlags is the number of reports after which a review is hidden
+// until a moderator looks at it.
+const autoHideFlags = 3
+
+// moderators are the subjects allowed to hide reviews and to see the
+// moderation queue; empty allows every caller with the write scope. Set
+// in main.
+var moderators []string
+
+// errForbidden is returned when the caller may not change a review.
+var errForbidden = errors.New("forbidden")
+
+// Validate reports every field of r that does not satisfy the Review
+// schema.
+func (r Review) Validate() []FieldError {
+	var errs []FieldError
+	if r.Rating < 1 || r.Rating > 5 {
+		errs = append(errs, FieldError{"rating", "must be between 1 and 5"})
+	}
+	if utf8.RuneCountInString(r.Text) > maxReviewText {
+		errs = append(errs, FieldError{"text", "must not exceed " + strconv.Itoa(maxReviewText) + " characters"})
+	}
+	return errs
+}
+
+// public returns r without the reporters of its flags.
+func (r Review) public() Review {
+	r.Flags = nil
+	return r
+}
+
+// canModerate reports whether the caller of r may moderate reviews.
+func canModerate(r *http.Request) bool {
+	if !hasWriteScope(r) {
+		return false
+	}
+	return len(moderators) == 0 || slices.Contains(moderators, actorFromContext(r.Context()))
+}
+
+// ratingOf summarizes the visible reviews among reviews.
+func ratingOf(reviews []Review) RatingSummary {
+	var sum, n int
+	for _, r := range reviews {
+		if !r.Hidden {
+			sum += r.Rating
+			n++
+		}
+	}
+	if n == 0 {
+		return RatingSummary{}
+	}
+	return RatingSummary{math.Round(float64(sum)/float64(n)*100) / 100, n}
+}
+
+// refreshRating recomputes the rating of book id on behalf of actor. The
+// caller must hold mutateMu.
+func refreshRating(actor, id string) error {
+	reviews, err := store.ListReviews(id)
+	if err != nil {
+		return err
+	}
+	book, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	if rating := ratingOf(reviews); rating != book.Rating {
+		book.Rating = rating
+		return storeAs(actor).Update(book)
+	}
+	return nil
+}
+
+// writeReviewError maps review errors to a JSON response.
+func writeReviewError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, 404, "Review not found")
+	case errors.Is(err, errForbidden):
+		writeError(w, 403, "Forbidden: only the reviewer or a moderator may do this")
+	default:
+		writeStoreError(w, r, err)
+	}
+}
+
+// handleListBookReviews lists the visible reviews of a book, newest first.
+func handleListBookReviews(w http.ResponseWriter, r *http.Request) {
+	limit, offset, fieldErrs := parsePage(r.URL.Query())
+	if fieldErrs != nil {
+		writeError(w, 400, "Invalid query parameters", fieldErrs...)
+		return
+	}
+	book, err := getLiveBook(r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	reviews, err := store.ListReviews(book.Id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	visible := []Review{}
+	for _, rev := range slices.Backward(reviews) {
+		if !rev.Hidden {
+			visible = append(visible, rev.public())
+		}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(visible)))
+	writeJSON(w, 200, page(visible, limit, offset))
+}
+
+// handleCreateReview posts a review of a book. Every reviewer may review
+// a book once; anonymous callers (no credentials configured) are not
+// limited.
+func handleCreateReview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rating int    `json:"rating"`
+		Text   string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	review := Review{
+		Id:        newID(),
+		BookId:    r.PathValue("id"),
+		Reviewer:  actorFromContext(r.Context()),
+		Rating:    req.Rating,
+		Text:      strings.TrimSpace(req.Text),
+		CreatedAt: time.Now().UTC(),
+	}
+	if fieldErrs := review.Validate(); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	if _, err := getLiveBook(review.BookId); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	reviews, err := store.ListReviews(review.BookId)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if review.Reviewer != "anonymous" && slices.ContainsFunc(reviews, func(o Review) bool { return o.Reviewer == review.Reviewer }) {
+		writeError(w, 409, review.Reviewer+" has already reviewed this book")
+		return
+	}
+	if err = store.SaveReview(review); err == nil {
+		err = refreshRating(review.Reviewer, review.BookId)
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/reviews/"+url.PathEscape(review.Id))
+	writeJSON(w, 201, review.public())
+}
+
+// handleGetReview returns a review; hidden ones only to moderators.
+func handleGetReview(w http.ResponseWriter, r *http.Request) {
+	review, err := store.GetReview(r.PathValue("id"))
+	if err == nil && review.Hidden && !canModerate(r) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		writeReviewError(w, r, err)
+		return
+	}
+	if !canModerate(r) {
+		review = review.public()
+	}
+	writeJSON(w, 200, review)
+}
+
+// handleDeleteReview removes a review. Reviewers may delete their own
+// reviews, moderators any.
+func handleDeleteReview(w http.ResponseWriter, r *http.Request) {
+	actor := actorFromContext(r.Context())
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	review, err := store.GetReview(r.PathValue("id"))
+	if err == nil && review.Reviewer != actor && !canModerate(r) {
+		err = errForbidden
+	}
+	if err == nil {
+		err = store.DeleteReview(review.Id)
+	}
+	if err == nil {
+		err = refreshRating(actor, review.BookId)
+	}
+	if err != nil {
+		writeReviewError(w, r, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handleFlagReview reports a review as abusive. Reporting twice counts
+// once; after autoHideFlags reports the review is hidden.
+func handleFlagReview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// the body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	actor := actorFromContext(r.Context())
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	review, err := store.GetReview(r.PathValue("id"))
+	if err != nil {
+		writeReviewError(w, r, err)
+		return
+	}
+	if !slices.ContainsFunc(review.Flags, func(f ReviewFlag) bool { return f.Reporter == actor }) {
+		review.Flags = append(review.Flags, ReviewFlag{actor, strings.TrimSpace(req.Reason), time.Now().UTC()})
+		hide := !review.Hidden && len(review.Flags) >= autoHideFlags
+		review.Hidden = review.Hidden || hide
+		if err = store.SaveReview(review); err == nil && hide {
+			err = refreshRating(actor, review.BookId)
+		}
+		if err != nil {
+			writeReviewError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, 200, Message{"Review reported"})
+}
+
+// handleModerateReview serves POST /reviews/{id}/{action} where action is
+// hide or unhide. Unhiding also dismisses the reports.
+func handleModerateReview(w http.ResponseWriter, r *http.Request) {
+	var hidden bool
+	switch r.PathValue("action") {
+	case "hide":
+		hidden = true
+	case "unhide":
+	default:
+		writeError(w, 404, "Unknown moderation action")
+		return
+	}
+	if !canModerate(r) {
+		writeError(w, 403, "Forbidden: "+actorFromContext(r.Context())+" is not a moderator")
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	review, err := store.GetReview(r.PathValue("id"))
+	if err != nil {
+		writeReviewError(w, r, err)
+		return
+	}
+	review.Hidden = hidden
+	if !hidden {
+		review.Flags = nil
+	}
+	if err = store.SaveReview(review); err == nil {
+		err = refreshRating(actorFromContext(r.Context()), review.BookId)
+	}
+	if err != nil {
+		writeReviewError(w, r, err)
+		return
+	}
+	writeJSON(w, 200, review)
+}
+
+// handleModerationQueue lists the reported and hidden reviews for the
+// moderators, most reported first.
+func handleModerationQueue(w http.ResponseWriter, r *http.Request) {
+	if !canModerate(r) {
+		writeError(w, 403, "Forbidden: "+actorFromContext(r.Context())+" is not a moderator")
+		return
+	}
+	reviews, err := store.ListReviews("")
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	queue := slices.DeleteFunc(reviews, func(rev Review) bool { return len(rev.Flags) == 0 && !rev.Hidden })
+	slices.SortStableFunc(queue, func(a, b Review) int { return len(b.Flags) - len(a.Flags) })
+	writeJSON(w, 200, queue)
+}
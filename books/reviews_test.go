@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRatingOf(t *testing.T) {
+	tests := []struct {
+		reviews []Review
+		want    RatingSummary
+	}{
+		{nil, RatingSummary{}},
+		{[]Review{{Rating: 4}}, RatingSummary{4, 1}},
+		{[]Review{{Rating: 5}, {Rating: 4}, {Rating: 4}}, RatingSummary{4.33, 3}},
+		{[]Review{{Rating: 1}, {Rating: 2}}, RatingSummary{1.5, 2}},
+		{[]Review{{Rating: 5}, {Rating: 1, Hidden: true}}, RatingSummary{5, 1}},
+		{[]Review{{Rating: 1, Hidden: true}}, RatingSummary{}},
+	}
+	for _, tt := range tests {
+		if got := ratingOf(tt.reviews); got != tt.want {
+			t.Errorf("ratingOf(%+v) = %+v, want %+v", tt.reviews, got, tt.want)
+		}
+	}
+}
+
+// newReviewServer is newTestServer behind API keys, as the reviewers
+// alice, bob and carol, the moderator mod and the read-only dash. It
+// returns a function that sends a request with the given key.
+func newReviewServer(t *testing.T) func(key, method, path, body string, want int) []byte {
+	t.Helper()
+	newTestServer(t)
+	auth, err := newAuthConfig("alice=ka,bob=kb,carol=kc,mod=km,dash=kr:read", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.pattern, rt.handler)
+	}
+	h := auth.middleware(mux)
+	saved := moderators
+	moderators = []string{"mod"}
+	t.Cleanup(func() { moderators = saved })
+
+	return func(key, method, path, body string, want int) []byte {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s %s with %s: status %d, want %d: %s", method, path, key, rec.Code, want, rec.Body)
+		}
+		return rec.Body.Bytes()
+	}
+}
+
+// ratingOfBook returns the rating stored on the book with the given id.
+func ratingOfBook(t *testing.T, id string) RatingSummary {
+	t.Helper()
+	b, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Rating
+}
+
+func TestReviewsUpdateTheRating(t *testing.T) {
+	as := newReviewServer(t)
+	as("ka", "POST", "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+
+	var alices Review
+	json.Unmarshal(as("ka", "POST", "/books/a/reviews", `{"rating":5,"text":" Classic "}`, 201), &alices)
+	if alices.Reviewer != "alice" || alices.Text != "Classic" || alices.BookId != "a" {
+		t.Errorf("created %+v", alices)
+	}
+	as("ka", "POST", "/books/a/reviews", `{"rating":4}`, 409)
+	as("kb", "POST", "/books/a/reviews", `{"rating":6}`, 400)
+	as("kr", "POST", "/books/a/reviews", `{"rating":3}`, 403)
+	as("kb", "POST", "/books/missing/reviews", `{"rating":3}`, 404)
+	as("kb", "POST", "/books/a/reviews", `{"rating":2}`, 201)
+	if got := ratingOfBook(t, "a"); got != (RatingSummary{3.5, 2}) {
+		t.Errorf("rating %+v, want 3.5 of 2", got)
+	}
+
+	var listed []Review
+	json.Unmarshal(as("kr", "GET", "/books/a/reviews", "", 200), &listed)
+	if len(listed) != 2 || listed[0].Reviewer != "bob" {
+		t.Errorf("listed %+v, want bob's review first", listed)
+	}
+
+	// only the reviewer or a moderator deletes a review
+	as("kc", "DELETE", "/reviews/"+alices.Id, "", 403)
+	as("ka", "DELETE", "/reviews/"+alices.Id, "", 204)
+	if got := ratingOfBook(t, "a"); got != (RatingSummary{2, 1}) {
+		t.Errorf("rating after deleting %+v, want 2 of 1", got)
+	}
+	as("ka", "DELETE", "/reviews/"+alices.Id, "", 404)
+}
+
+func TestReviewModeration(t *testing.T) {
+	as := newReviewServer(t)
+	as("ka", "POST", "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	as("ka", "POST", "/books/a/reviews", `{"rating":5}`, 201)
+	var bobs Review
+	json.Unmarshal(as("kb", "POST", "/books/a/reviews", `{"rating":1,"text":"spam"}`, 201), &bobs)
+	path := "/reviews/" + bobs.Id
+
+	// hidden after autoHideFlags reporters; reporting twice counts once
+	for _, key := range []string{"ka", "ka", "kc"} {
+		as(key, "POST", path+"/flag", `{"reason":"spam"}`, 200)
+	}
+	if got := ratingOfBook(t, "a"); got != (RatingSummary{3, 2}) {
+		t.Errorf("rating after 2 reports %+v, want 3 of 2", got)
+	}
+	as("km", "POST", path+"/flag", "", 200)
+	if got := ratingOfBook(t, "a"); got != (RatingSummary{5, 1}) {
+		t.Errorf("rating after 3 reports %+v, want 5 of 1", got)
+	}
+
+	// hidden reviews and their reporters are only shown to moderators
+	var listed []Review
+	json.Unmarshal(as("kb", "GET", "/books/a/reviews", "", 200), &listed)
+	if len(listed) != 1 || listed[0].Reviewer != "alice" {
+		t.Errorf("listed %+v, want only alice's review", listed)
+	}
+	as("kb", "GET", path, "", 404)
+	var got Review
+	json.Unmarshal(as("km", "GET", path, "", 200), &got)
+	if !got.Hidden || len(got.Flags) != 3 {
+		t.Errorf("moderator sees %+v, want hidden with 3 reports", got)
+	}
+	as("ka", "GET", "/reviews", "", 403)
+	var queue []Review
+	json.Unmarshal(as("km", "GET", "/reviews", "", 200), &queue)
+	if len(queue) != 1 || queue[0].Id != bobs.Id {
+		t.Errorf("queue %+v, want bob's review", queue)
+	}
+
+	// unhiding dismisses the reports and counts the rating again
+	as("ka", "POST", path+"/unhide", "", 403)
+	as("km", "POST", path+"/approve", "", 404)
+	as("km", "POST", path+"/unhide", "", 200)
+	if got := ratingOfBook(t, "a"); got != (RatingSummary{3, 2}) {
+		t.Errorf("rating after unhiding %+v, want 3 of 2", got)
+	}
+	json.Unmarshal(as("km", "GET", "/reviews", "", 200), &queue)
+	if len(queue) != 0 {
+		t.Errorf("queue after unhiding %+v, want none", queue)
+	}
+	as("km", "POST", path+"/hide", "", 200)
+	if got := ratingOfBook(t, "a"); got != (RatingSummary{5, 1}) {
+		t.Errorf("rating after hiding %+v, want 5 of 1", got)
+	}
+	as("km", "POST", "/reviews/missing/hide", "", 404)
+
+	// without a list of moderators every writer moderates
+	moderators = nil
+	as("kc", "POST", path+"/unhide", "", 200)
+	as("kr", "POST", path+"/hide", "", 403)
+}
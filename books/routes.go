@@ -0,0 +1,111 @@
+package main
+
+import "net/http"
+
+// route pairs a ServeMux pattern with its handler. Every route must be
+// described in openapi.json; see checkOpenAPISpec.
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+var routes = []route{
+	// http://localhost:8080
+	{"/", handleGetBooks},
+
+	// http://localhost:8080/book?id=1
+	{"/book", handleGetBookById},
+
+	// http://localhost:8080/add
+	{"/add", handleAddBook},
+
+	// RESTful resource routes
+	{"GET /books", handleGetBooks},
+	{"POST /books", handleCreateBook},
+	{"GET /books/{id}", handleGetBookById},
+	{"PUT /books/{id}", handleReplaceBook},
+	{"PATCH /books/{id}", handlePatchBook},
+	{"DELETE /books/{id}", handleDeleteBook},
+
+	// live catalog changes as Server-Sent Events
+	{"GET /books/events", handleBookEvents},
+
+	// audit trail: seq is the revision to restore
+	{"GET /books/{id}/history", handleBookHistory},
+	{"POST /books/{id}/history/{seq}/restore", handleRestoreRevision},
+
+	// prices: history from the audit log, conversions via the rate table
+	{"GET /books/{id}/prices", handleBookPrices},
+	{"GET /exchange-rates", handleExchangeRates},
+
+	// authors and categories books refer to by id
+	{"GET /authors", handleListAuthors},
+	{"POST /authors", handleCreateAuthor},
+	{"GET /authors/{id}", handleGetAuthor},
+	{"PUT /authors/{id}", handleReplaceAuthor},
+	{"DELETE /authors/{id}", handleDeleteAuthor},
+	{"GET /authors/{id}/books", handleListAuthorBooks},
+	{"GET /categories", handleListCategories},
+	{"POST /categories", handleCreateCategory},
+	{"GET /categories/{id}", handleGetCategory},
+	{"PUT /categories/{id}", handleReplaceCategory},
+	{"DELETE /categories/{id}", handleDeleteCategory},
+	{"GET /categories/{id}/books", handleListCategoryBooks},
+
+	// customer reviews; action is hide or unhide (moderators only)
+	{"GET /books/{id}/reviews", handleListBookReviews},
+	{"POST /books/{id}/reviews", handleCreateReview},
+	{"GET /reviews", handleModerationQueue},
+	{"GET /reviews/{id}", handleGetReview},
+	{"DELETE /reviews/{id}", handleDeleteReview},
+	{"POST /reviews/{id}/flag", handleFlagReview},
+	{"POST /reviews/{id}/{action}", handleModerateReview},
+
+	// webhook subscriptions and their delivery log
+	{"GET /webhooks", handleListWebhooks},
+	{"POST /webhooks", handleCreateWebhook},
+	{"GET /webhooks/{id}", handleGetWebhook},
+	{"PUT /webhooks/{id}", handleReplaceWebhook},
+	{"DELETE /webhooks/{id}", handleDeleteWebhook},
+	{"GET /webhooks/{id}/deliveries", handleListDeliveries},
+	{"POST /webhooks/{id}/deliveries/{deliveryId}/redeliver", handleRedeliver},
+
+	// soft-deleted books
+	{"GET /trash", handleListTrash},
+	{"POST /trash/{id}/restore", handleRestoreFromTrash},
+	{"DELETE /trash/{id}", handlePurgeFromTrash},
+
+	// stock tracking: action is reserve, release or adjust
+	{"GET /books/{id}/inventory", handleGetInventory},
+	{"POST /books/{id}/inventory/{action}", handleStockChange},
+	{"GET /inventory/low-stock", handleLowStock},
+
+	// cover images: multipart upload and the stored files
+	{"POST /books/{id}/cover", handleUploadCover},
+	{"GET /covers/{name}", handleGetCover},
+
+	// shopping carts and orders
+	{"POST /carts", handleCreateCart},
+	{"GET /carts/{id}", handleGetCart},
+	{"DELETE /carts/{id}", handleDeleteCart},
+	{"PUT /carts/{id}/items/{bookId}", handleSetCartItem},
+	{"DELETE /carts/{id}/items/{bookId}", handleRemoveCartItem},
+	{"POST /carts/{id}/checkout", handleCheckout},
+	{"GET /orders", handleListOrders},
+	{"GET /orders/{id}", handleGetOrder},
+
+	// bulk transfer in csv, ndjson or json
+	{"POST /books/import", handleImportBooks},
+	{"GET /books/export", handleExportBooks},
+
+	// GraphQL queries (GET or POST) and mutations (POST)
+	{"GET /graphql", handleGraphQL},
+	{"POST /graphql", handleGraphQL},
+	{"GET /graphql/schema", handleGraphQLSchema},
+
+	// http://localhost:8080/metrics (Prometheus text format)
+	{"GET /metrics", handleMetrics},
+
+	// http://localhost:8080/openapi.json
+	{"GET /openapi.json", handleOpenAPI},
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps one row per book. The book itself is stored as a JSON
+// document so new Book fields do not need a schema migration.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the SQLite file at dbPath and runs the
+// migration that creates the `books` table if it does not exist.
+// The caller must call Close() when the program shuts down.
+func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
+	// The modernc.org driver is pure‑go and works without CGO.
+	dsn := fmt.Sprintf("file:%s?_fk=1", dbPath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite db: %w", err)
+	}
+
+	// SQLite allows a single writer; one connection serializes mutations
+	// instead of surfacing SQLITE_BUSY to concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	// Verify the connection quickly.
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ping sqlite db: %w", err)
+	}
+
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("run migration: %w", err)
+	}
+	return s, nil
+}
+
+func (s *SQLiteStore) migrate() error {
+	const stmt = `
+CREATE TABLE IF NOT EXISTS books (
+    seq  INTEGER PRIMARY KEY AUTOINCREMENT,
+    id   TEXT NOT NULL UNIQUE,
+    data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS authors (
+    id   TEXT PRIMARY KEY,
+    data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS categories (
+    id   TEXT PRIMARY KEY,
+    data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS reviews (
+    id      TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL,
+    data    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS reviews_book_id ON reviews (book_id);
+CREATE TABLE IF NOT EXISTS carts (
+    id   TEXT PRIMARY KEY,
+    data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS orders (
+    seq  INTEGER PRIMARY KEY AUTOINCREMENT,
+    id   TEXT NOT NULL UNIQUE,
+    data TEXT NOT NULL
+);
+`
+	_, err := s.db.Exec(stmt)
+	if err != nil {
+		return fmt.Errorf("create tables: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Get(id string) (Book, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM books WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Book{}, ErrNotFound
+	}
+	if err != nil {
+		return Book{}, fmt.Errorf("select book %s: %w", id, err)
+	}
+	var book Book
+	if err := json.Unmarshal(data, &book); err != nil {
+		return Book{}, fmt.Errorf("decode book %s: %w", id, err)
+	}
+	return book, nil
+}
+
+// List returns the books in insertion order, like the JSON store.
+func (s *SQLiteStore) List() ([]Book, error) {
+	rows, err := s.db.Query(`SELECT data FROM books ORDER BY seq`)
+	if err != nil {
+		return nil, fmt.Errorf("select books: %w", err)
+	}
+	defer rows.Close()
+
+	books := []Book{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("scan book: %w", err)
+		}
+		var book Book
+		if err := json.Unmarshal(data, &book); err != nil {
+			return nil, fmt.Errorf("decode book: %w", err)
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+func (s *SQLiteStore) Create(book Book) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return fmt.Errorf("encode book %s: %w", book.Id, err)
+	}
+	res, err := s.db.Exec(`INSERT INTO books (id, data) VALUES (?, ?) ON CONFLICT(id) DO NOTHING`, book.Id, data)
+	if err != nil {
+		return fmt.Errorf("insert book %s: %w", book.Id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Update(book Book) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return fmt.Errorf("encode book %s: %w", book.Id, err)
+	}
+	res, err := s.db.Exec(`UPDATE books SET data = ? WHERE id = ?`, data, book.Id)
+	if err != nil {
+		return fmt.Errorf("update book %s: %w", book.Id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes the book and its reviews in a single transaction.
+func (s *SQLiteStore) Delete(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() // no-op after Commit
+
+	res, err := tx.Exec(`DELETE FROM books WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete book %s: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM reviews WHERE book_id = ?`, id); err != nil {
+		return fmt.Errorf("delete reviews of %s: %w", id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// ListAuthors returns the authors in insertion order.
+// SaveBatch writes everything in one transaction.
+func (s *SQLiteStore) SaveBatch(authors []Author, create, update []Book) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() // no-op after Commit
+
+	for _, author := range authors {
+		data, err := json.Marshal(author)
+		if err != nil {
+			return fmt.Errorf("encode author %s: %w", author.Id, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO authors (id, data) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET data = excluded.data`, author.Id, data); err != nil {
+			return fmt.Errorf("save author %s: %w", author.Id, err)
+		}
+	}
+	for _, book := range create {
+		data, err := json.Marshal(book)
+		if err != nil {
+			return fmt.Errorf("encode book %s: %w", book.Id, err)
+		}
+		res, err := tx.Exec(`INSERT INTO books (id, data) VALUES (?, ?) ON CONFLICT(id) DO NOTHING`, book.Id, data)
+		if err != nil {
+			return fmt.Errorf("insert book %s: %w", book.Id, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("book %s: %w", book.Id, ErrExists)
+		}
+	}
+	for _, book := range update {
+		data, err := json.Marshal(book)
+		if err != nil {
+			return fmt.Errorf("encode book %s: %w", book.Id, err)
+		}
+		res, err := tx.Exec(`UPDATE books SET data = ? WHERE id = ?`, data, book.Id)
+		if err != nil {
+			return fmt.Errorf("update book %s: %w", book.Id, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("book %s: %w", book.Id, ErrNotFound)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListAuthors() ([]Author, error) {
+	authors := []Author{}
+	err := s.listDocuments(`SELECT data FROM authors ORDER BY rowid`, func(data []byte) error {
+		var a Author
+		if err := json.Unmarshal(data, &a); err != nil {
+			return fmt.Errorf("decode author: %w", err)
+		}
+		authors = append(authors, a)
+		return nil
+	})
+	return authors, err
+}
+
+func (s *SQLiteStore) GetAuthor(id string) (Author, error) {
+	var author Author
+	return author, s.getDocument(`SELECT data FROM authors WHERE id = ?`, id, &author)
+}
+
+func (s *SQLiteStore) SaveAuthor(author Author) error {
+	return s.saveDocument(`INSERT INTO authors (id, data) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET data = excluded.data`, author.Id, author)
+}
+
+func (s *SQLiteStore) DeleteAuthor(id string) error {
+	return s.deleteDocument(`DELETE FROM authors WHERE id = ?`, id)
+}
+
+// ListCategories returns the categories in insertion order.
+func (s *SQLiteStore) ListCategories() ([]Category, error) {
+	categories := []Category{}
+	err := s.listDocuments(`SELECT data FROM categories ORDER BY rowid`, func(data []byte) error {
+		var c Category
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("decode category: %w", err)
+		}
+		categories = append(categories, c)
+		return nil
+	})
+	return categories, err
+}
+
+func (s *SQLiteStore) GetCategory(id string) (Category, error) {
+	var category Category
+	return category, s.getDocument(`SELECT data FROM categories WHERE id = ?`, id, &category)
+}
+
+func (s *SQLiteStore) SaveCategory(category Category) error {
+	return s.saveDocument(`INSERT INTO categories (id, data) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET data = excluded.data`, category.Id, category)
+}
+
+func (s *SQLiteStore) DeleteCategory(id string) error {
+	return s.deleteDocument(`DELETE FROM categories WHERE id = ?`, id)
+}
+
+// ListReviews returns the reviews in insertion order.
+func (s *SQLiteStore) ListReviews(bookId string) ([]Review, error) {
+	query, args := `SELECT data FROM reviews ORDER BY rowid`, []any{}
+	if bookId != "" {
+		query, args = `SELECT data FROM reviews WHERE book_id = ? ORDER BY rowid`, []any{bookId}
+	}
+	reviews := []Review{}
+	err := s.listDocuments(query, func(data []byte) error {
+		var r Review
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("decode review: %w", err)
+		}
+		reviews = append(reviews, r)
+		return nil
+	}, args...)
+	return reviews, err
+}
+
+func (s *SQLiteStore) GetReview(id string) (Review, error) {
+	var review Review
+	return review, s.getDocument(`SELECT data FROM reviews WHERE id = ?`, id, &review)
+}
+
+func (s *SQLiteStore) SaveReview(review Review) error {
+	data, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("encode review %s: %w", review.Id, err)
+	}
+	_, err = s.db.Exec(`INSERT INTO reviews (id, book_id, data) VALUES (?, ?, ?) ON CONFLICT(id) DO UPDATE SET data = excluded.data`, review.Id, review.BookId, data)
+	if err != nil {
+		return fmt.Errorf("save review %s: %w", review.Id, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteReview(id string) error {
+	return s.deleteDocument(`DELETE FROM reviews WHERE id = ?`, id)
+}
+
+func (s *SQLiteStore) GetCart(id string) (Cart, error) {
+	var cart Cart
+	return cart, s.getDocument(`SELECT data FROM carts WHERE id = ?`, id, &cart)
+}
+
+func (s *SQLiteStore) SaveCart(cart Cart) error {
+	data, err := json.Marshal(cart)
+	if err != nil {
+		return fmt.Errorf("encode cart %s: %w", cart.Id, err)
+	}
+	_, err = s.db.Exec(`INSERT INTO carts (id, data) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET data = excluded.data`, cart.Id, data)
+	if err != nil {
+		return fmt.Errorf("save cart %s: %w", cart.Id, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteCart(id string) error {
+	res, err := s.db.Exec(`DELETE FROM carts WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete cart %s: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetOrder(id string) (Order, error) {
+	var order Order
+	return order, s.getDocument(`SELECT data FROM orders WHERE id = ?`, id, &order)
+}
+
+func (s *SQLiteStore) ListOrders() ([]Order, error) {
+	rows, err := s.db.Query(`SELECT data FROM orders ORDER BY seq`)
+	if err != nil {
+		return nil, fmt.Errorf("select orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("scan order: %w", err)
+		}
+		var order Order
+		if err := json.Unmarshal(data, &order); err != nil {
+			return nil, fmt.Errorf("decode order: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// PlaceOrder applies the whole checkout in a single transaction.
+func (s *SQLiteStore) PlaceOrder(order Order, books []Book, cartId string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback() // no-op after Commit
+
+	for _, book := range books {
+		data, err := json.Marshal(book)
+		if err != nil {
+			return fmt.Errorf("encode book %s: %w", book.Id, err)
+		}
+		res, err := tx.Exec(`UPDATE books SET data = ? WHERE id = ?`, data, book.Id)
+		if err != nil {
+			return fmt.Errorf("update book %s: %w", book.Id, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("book %s: %w", book.Id, ErrNotFound)
+		}
+	}
+
+	data, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("encode order %s: %w", order.Id, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO orders (id, data) VALUES (?, ?)`, order.Id, data); err != nil {
+		return fmt.Errorf("insert order %s: %w", order.Id, err)
+	}
+
+	res, err := tx.Exec(`DELETE FROM carts WHERE id = ?`, cartId)
+	if err != nil {
+		return fmt.Errorf("delete cart %s: %w", cartId, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// getDocument decodes the JSON data column returned by query into dst.
+func (s *SQLiteStore) getDocument(query, id string, dst any) error {
+	var data []byte
+	err := s.db.QueryRow(query, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("select %s: %w", id, err)
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("decode %s: %w", id, err)
+	}
+	return nil
+}
+
+// listDocuments calls decode with the JSON data column of every row
+// returned by query.
+func (s *SQLiteStore) listDocuments(query string, decode func(data []byte) error, args ...any) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("select: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+		if err := decode(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// saveDocument encodes v as JSON and runs the upsert stmt with id and the
+// data.
+func (s *SQLiteStore) saveDocument(stmt, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", id, err)
+	}
+	if _, err := s.db.Exec(stmt, id, data); err != nil {
+		return fmt.Errorf("save %s: %w", id, err)
+	}
+	return nil
+}
+
+// deleteDocument runs the delete stmt with id and returns ErrNotFound if
+// it removed nothing.
+func (s *SQLiteStore) deleteDocument(stmt, id string) error {
+	res, err := s.db.Exec(stmt, id)
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Close shuts down the database connection.
+func (s *SQLiteStore) Close() error {
+	if s.db != nil {
+		return s.db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when no book, author, category, review,
+	// webhook, delivery, cart or order with the requested id exists.
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned by Create when a book with the same id exists.
+	ErrExists = errors.New("book already exists")
+)
+
+// BookStore abstracts the persistence back-end for the catalog.
+type BookStore interface {
+	// Get returns the book with the given id or ErrNotFound.
+	Get(id string) (Book, error)
+
+	// List returns every book in the catalog.
+	List() ([]Book, error)
+
+	// Create adds a new book. It returns ErrExists if the id is taken.
+	Create(book Book) error
+
+	// Update replaces an existing book. It returns ErrNotFound if the id
+	// is unknown.
+	Update(book Book) error
+
+	// Delete removes the book with the given id and its reviews, or
+	// returns ErrNotFound.
+	Delete(id string) error
+
+	// SaveBatch creates or replaces authors, adds the books of create and
+	// replaces those of update in a single write. It returns ErrExists if
+	// a book of create exists, or ErrNotFound if one of update does not,
+	// and then saves nothing.
+	SaveBatch(authors []Author, create, update []Book) error
+
+	// ListAuthors returns every author.
+	ListAuthors() ([]Author, error)
+
+	// GetAuthor returns the author with the given id or ErrNotFound.
+	GetAuthor(id string) (Author, error)
+
+	// SaveAuthor creates or replaces an author.
+	SaveAuthor(author Author) error
+
+	// DeleteAuthor removes the author with the given id or returns
+	// ErrNotFound.
+	DeleteAuthor(id string) error
+
+	// ListCategories returns every category.
+	ListCategories() ([]Category, error)
+
+	// GetCategory returns the category with the given id or ErrNotFound.
+	GetCategory(id string) (Category, error)
+
+	// SaveCategory creates or replaces a category.
+	SaveCategory(category Category) error
+
+	// DeleteCategory removes the category with the given id or returns
+	// ErrNotFound.
+	DeleteCategory(id string) error
+
+	// ListReviews returns the reviews of the book with the given id, or
+	// every review if bookId is empty, oldest first.
+	ListReviews(bookId string) ([]Review, error)
+
+	// GetReview returns the review with the given id or ErrNotFound.
+	GetReview(id string) (Review, error)
+
+	// SaveReview creates or replaces a review.
+	SaveReview(review Review) error
+
+	// DeleteReview removes the review with the given id or returns
+	// ErrNotFound.
+	DeleteReview(id string) error
+
+	// GetCart returns the cart with the given id or ErrNotFound.
+	GetCart(id string) (Cart, error)
+
+	// SaveCart creates or replaces a cart.
+	SaveCart(cart Cart) error
+
+	// DeleteCart removes the cart with the given id or returns ErrNotFound.
+	DeleteCart(id string) error
+
+	// GetOrder returns the order with the given id or ErrNotFound.
+	GetOrder(id string) (Order, error)
+
+	// ListOrders returns every order, oldest first.
+	ListOrders() ([]Order, error)
+
+	// PlaceOrder stores order, replaces the given books (with their
+	// stock already decremented) and deletes the cart it was created
+	// from. The implementation must guarantee atomicity.
+	PlaceOrder(order Order, books []Book, cartId string) error
+
+	// Close releases any resources (e.g. DB connections).
+	Close() error
+}
+
+// openStore returns the BookStore selected by kind ("json" or "sqlite")
+// backed by the file at path.
+func openStore(kind, path string) (BookStore, error) {
+	switch kind {
+	case "json":
+		return NewJSONStore(path), nil
+	case "sqlite":
+		s, err := NewSQLiteStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Supported bulk transfer formats.
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// Conflict policies for importing a book whose id already exists.
+const (
+	onConflictSkip   = "skip"
+	onConflictUpsert = "upsert"
+)
+
+// csvColumns is the header written by exports and understood by imports.
+// Imports need at least a title column; rows without an id get a new one.
+var csvColumns = []string{"id", "title", "author", "price", "image_url"}
+
+var formatContentTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatNDJSON: "application/x-ndjson",
+	formatCSV:    "text/csv",
+}
+
+// ImportRow reports what happened to one record of an import.
+type ImportRow struct {
+	Row    int          `json:"row"` // 1-based record number, excluding the CSV header
+	Id     string       `json:"id,omitempty"`
+	Status string       `json:"status"` // created|updated|skipped|invalid
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// ImportReport summarizes an import and lists the outcome of every row.
+type ImportReport struct {
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Invalid int         `json:"invalid"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// importRecord is a decoded record, or the reason it could not be decoded.
+type importRecord struct {
+	book Book
+	err  error
+}
+
+// decodeBooks reads every record of r in the given format. Records that
+// cannot be decoded are returned with an error so they show up in the
+// report; only an unreadable stream as a whole fails the call.
+func decodeBooks(r io.Reader, format string) ([]importRecord, error) {
+	switch format {
+	case formatJSON:
+		var books []Book
+		if err := json.NewDecoder(r).Decode(&books); err != nil {
+			return nil, fmt.Errorf("decode json array: %w", err)
+		}
+		records := make([]importRecord, len(books))
+		for i, b := range books {
+			records[i].book = b
+		}
+		return records, nil
+
+	case formatNDJSON:
+		var records []importRecord
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var rec importRecord
+			rec.err = json.Unmarshal(line, &rec.book)
+			records = append(records, rec)
+		}
+		return records, sc.Err()
+
+	case formatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("read csv header: %w", err)
+		}
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := columns["title"]; !ok {
+			return nil, errors.New(`csv header must contain a "title" column`)
+		}
+
+		var records []importRecord
+		for {
+			fields, err := cr.Read()
+			if err == io.EOF {
+				return records, nil
+			}
+			var rec importRecord
+			if err != nil {
+				var parseErr *csv.ParseError
+				if !errors.As(err, &parseErr) {
+					return nil, err
+				}
+				rec.err = err
+			} else {
+				rec.book, rec.err = bookFromCSV(columns, fields)
+			}
+			records = append(records, rec)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func bookFromCSV(columns map[string]int, fields []string) (Book, error) {
+	if len(fields) != len(columns) {
+		return Book{}, fmt.Errorf("expected %d fields, got %d", len(columns), len(fields))
+	}
+	get := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	return Book{
+		Id:       get("id"),
+		Title:    get("title"),
+		Author:   get("author"),
+		Price:    priceFromString(get("price")),
+		Imageurl: get("image_url"),
+	}, nil
+}
+
+// importBooks validates and stores records according to onConflict on
+// behalf of actor and reports the outcome of each one. Upserted books keep
+// their server-managed fields, and their categories unless the record
+// lists some; a book in the trash has to be restored before an upsert.
+func importBooks(actor string, records []importRecord, onConflict string) (ImportReport, error) {
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	// sort the rows into books to create and to update, then save them
+	// with one write
+	var (
+		linker authorLinker
+		saved  []Book
+		index  = map[string]int{}  // position in saved by id
+		isNew  = map[string]bool{} // ids of the saved books to create
+	)
+	report := ImportReport{Rows: make([]ImportRow, 0, len(records))}
+	for i, rec := range records {
+		if rec.err == nil && rec.book.Id == "" {
+			rec.book.Id = newID()
+		}
+		resetServerFields(&rec.book)
+		row := ImportRow{Row: i + 1, Id: rec.book.Id}
+		switch {
+		case rec.err != nil:
+			row.Status = "invalid"
+			row.Errors = []FieldError{{"", rec.err.Error()}}
+		case rec.book.Validate() != nil:
+			row.Status = "invalid"
+			row.Errors = rec.book.Validate()
+		default:
+			current, err := store.Get(rec.book.Id)
+			exists := err == nil
+			if errors.Is(err, ErrNotFound) {
+				err = nil
+			}
+			if err != nil {
+				return report, err
+			}
+			j, seen := index[rec.book.Id]
+			if seen {
+				current, exists = saved[j], true
+			}
+			if exists && onConflict != onConflictUpsert {
+				row.Status = "skipped"
+				break
+			}
+			if exists && !current.DeletedAt.IsZero() {
+				// an upsert would update the book but leave it in the trash
+				row.Status = "invalid"
+				row.Errors = []FieldError{{"id", "belongs to a book in the trash; restore it first"}}
+				break
+			}
+			if exists {
+				preserveServerFields(&rec.book, current)
+				// CSV rows carry no categories; keep the book's
+				if rec.book.CategoryIds == nil {
+					rec.book.CategoryIds = current.CategoryIds
+				}
+			}
+			fieldErrs, err := linker.link(&rec.book, current)
+			if err != nil {
+				return report, err
+			}
+			if fieldErrs != nil {
+				row.Status = "invalid"
+				row.Errors = fieldErrs
+				break
+			}
+			switch {
+			case !exists:
+				row.Status = "created"
+				index[rec.book.Id] = len(saved)
+				isNew[rec.book.Id] = true
+				saved = append(saved, rec.book)
+			case seen:
+				row.Status = "updated"
+				saved[j] = rec.book
+			default:
+				row.Status = "updated"
+				index[rec.book.Id] = len(saved)
+				saved = append(saved, rec.book)
+			}
+		}
+
+		switch row.Status {
+		case "created":
+			report.Created++
+		case "updated":
+			report.Updated++
+		case "skipped":
+			report.Skipped++
+		case "invalid":
+			report.Invalid++
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	if len(saved) > 0 {
+		var create, update []Book
+		for _, book := range saved {
+			if isNew[book.Id] {
+				create = append(create, book)
+			} else {
+				update = append(update, book)
+			}
+		}
+		authors := linker.usedBy(saved)
+		if err := storeAs(actor).SaveBatch(authors, create, update); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// encodeBooks writes books to w in the given format.
+func encodeBooks(w io.Writer, books []Book, format string) error {
+	switch format {
+	case formatJSON:
+		return json.NewEncoder(w).Encode(books)
+
+	case formatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, b := range books {
+			if err := enc.Encode(b); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(csvColumns)
+		for _, b := range books {
+			price := ""
+			if !b.Price.IsZero() {
+				price = b.Price.String()
+			}
+			cw.Write([]string{b.Id, b.Title, b.Author, price, b.Imageurl})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// requestFormat picks the transfer format from ?format=, falling back to
+// the media type of header (Content-Type or Accept) and then to JSON.
+func requestFormat(r *http.Request, header string) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if _, ok := formatContentTypes[f]; !ok {
+			return "", fmt.Errorf("unsupported format %q", f)
+		}
+		return f, nil
+	}
+	for _, part := range strings.Split(r.Header.Get(header), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		for f, ct := range formatContentTypes {
+			if mediaType == ct {
+				return f, nil
+			}
+		}
+	}
+	return formatJSON, nil
+}
+
+// handleImportBooks loads books in bulk, e.g.
+//
+//	curl -X POST -H 'Content-Type: text/csv' --data-binary @books.csv 'localhost:8080/books/import?on_conflict=upsert'
+func handleImportBooks(w http.ResponseWriter, r *http.Request) {
+	format, err := requestFormat(r, "Content-Type")
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	onConflict := r.URL.Query().Get("on_conflict")
+	if onConflict == "" {
+		onConflict = onConflictSkip
+	}
+	if onConflict != onConflictSkip && onConflict != onConflictUpsert {
+		writeError(w, 400, "Invalid query parameters", FieldError{"on_conflict", "must be skip or upsert"})
+		return
+	}
+
+	records, err := decodeBooks(r.Body, format)
+	if err != nil {
+		writeBodyError(w, err, "Bad Request: "+err.Error())
+		return
+	}
+	if tooManyBooks(w, len(records)) {
+		return
+	}
+	report, err := importBooks(actorFromContext(r.Context()), records, onConflict)
+	if err != nil {
+		logError(r, err)
+		writeError(w, 500, fmt.Sprintf("Internal server error, import stopped after row %d", len(report.Rows)))
+		return
+	}
+	writeJSON(w, 200, report)
+}
+
+// handleExportBooks downloads the whole catalog, e.g.
+//
+//	curl 'localhost:8080/books/export?format=ndjson'
+func handleExportBooks(w http.ResponseWriter, r *http.Request) {
+	format, err := requestFormat(r, "Accept")
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	books, err := getBooks()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="books.`+format+`"`)
+	if err := encodeBooks(w, books, format); err != nil {
+		logError(r, err)
+	}
+}
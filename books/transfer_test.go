@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImportBooksSavesOnce(t *testing.T) {
+	_, path := newTestServer(t)
+	record := func(id, title, author string) importRecord {
+		return importRecord{book: Book{Id: id, Title: title, Author: author, Price: Money{Amount: 100, Currency: "USD"}}}
+	}
+	records := []importRecord{
+		record("a", "First", "Ann Author"),
+		record("b", "Second", "ann author"),
+		record("a", "First again", "Ann Author"),
+		record("", "", "Nobody"), // invalid, its author must not be created
+	}
+
+	report, err := importBooks("test", records, onConflictUpsert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 2 || report.Updated != 1 || report.Invalid != 1 {
+		t.Errorf("report = %+v, want 2 created, 1 updated, 1 invalid", report)
+	}
+
+	c, err := readCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Books) != 2 || c.Books[0].Title != "First again" {
+		t.Errorf("books = %+v, want a (updated) and b", c.Books)
+	}
+	if len(c.Authors) != 1 || c.Books[0].AuthorId != c.Authors[0].Id || c.Books[1].AuthorId != c.Authors[0].Id {
+		t.Errorf("authors = %+v, want one author shared by both books", c.Authors)
+	}
+	events, err := audit.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("%d audit events, want 2 creates", len(events))
+	}
+
+	report, err = importBooks("test", records[:1], onConflictSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 1 {
+		t.Errorf("second import: report = %+v, want 1 skipped", report)
+	}
+}
+
+func TestCSVRoundTripKeepsCategories(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/categories", `{"id":"sf","name":"Science Fiction"}`, 201)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99","category_ids":["sf"]}`, 201)
+
+	csv := send(t, srv, "GET", "/books/export?format=csv", "", 200)
+	edited := strings.Replace(string(csv), "Dune", "Dune Messiah", 1)
+	var report ImportReport
+	if err := json.Unmarshal(post(t, srv, "/books/import?format=csv&on_conflict=upsert", edited, 200), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 {
+		t.Fatalf("report = %+v, want 1 updated", report)
+	}
+	book, err := store.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "Dune Messiah" || !slices.Equal(book.CategoryIds, []string{"sf"}) {
+		t.Errorf("after the import: %+v, want the new title and category sf", book)
+	}
+
+	// a record listing categories replaces them
+	post(t, srv, "/books/import?on_conflict=upsert", `[{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99","category_ids":[]}]`, 200)
+	if book, _ = store.Get("a"); len(book.CategoryIds) != 0 {
+		t.Errorf("category_ids = %v, want none", book.CategoryIds)
+	}
+}
+
+func TestUpsertRejectsTrashedBooks(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	send(t, srv, "DELETE", "/books/a", "", 204)
+
+	var report ImportReport
+	body := post(t, srv, "/books/import?on_conflict=upsert", `[{"id":"a","title":"Dune Messiah","author":"Frank Herbert","price":"9.99"}]`, 200)
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Invalid != 1 || report.Rows[0].Status != "invalid" || report.Rows[0].Errors[0].Field != "id" {
+		t.Errorf("report = %s, want the row invalid because of its id", body)
+	}
+	if book, _ := store.Get("a"); book.Title != "Dune" || book.DeletedAt.IsZero() {
+		t.Errorf("trashed book = %+v, want it unchanged", book)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// TrashedBook is a soft-deleted book as listed in the trash.
+type TrashedBook struct {
+	Book
+	PurgeAt *time.Time `json:"purge_at,omitempty"` // nil if purging is disabled
+}
+
+// trashRetention is how long soft-deleted books are kept before the purge
+// job removes them for good; 0 keeps them forever. Set in main.
+var trashRetention = 30 * 24 * time.Hour
+
+// getLiveBook returns the book with the given id, or ErrNotFound if it
+// does not exist or is in the trash.
+func getLiveBook(id string) (Book, error) {
+	book, err := store.Get(id)
+	if err == nil && book.Deleted() {
+		return Book{}, ErrNotFound
+	}
+	return book, err
+}
+
+// Deleted reports whether the book is in the trash.
+func (b Book) Deleted() bool {
+	return !b.DeletedAt.IsZero()
+}
+
+// getTrashedBook returns the book with the given id if it is in the trash.
+func getTrashedBook(id string) (Book, error) {
+	book, err := store.Get(id)
+	if err == nil && !book.Deleted() {
+		return Book{}, ErrNotFound
+	}
+	return book, err
+}
+
+func handleListTrash(w http.ResponseWriter, r *http.Request) {
+	books, err := store.List()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	trashed := []TrashedBook{}
+	for _, b := range books {
+		if !b.Deleted() {
+			continue
+		}
+		tb := TrashedBook{Book: b}
+		if trashRetention > 0 {
+			purgeAt := b.DeletedAt.Add(trashRetention)
+			tb.PurgeAt = &purgeAt
+		}
+		trashed = append(trashed, tb)
+	}
+	// most recently deleted first
+	slices.SortStableFunc(trashed, func(a, b TrashedBook) int { return b.DeletedAt.Compare(a.DeletedAt) })
+	writeJSON(w, 200, trashed)
+}
+
+// handleRestoreFromTrash makes a soft-deleted book visible again.
+func handleRestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	book, err := getTrashedBook(r.PathValue("id"))
+	if err != nil {
+		writeError(w, 404, "Book not in trash")
+		return
+	}
+	book.DeletedAt = time.Time{}
+	if err := storeAs(actorFromContext(r.Context())).Update(book); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etagOf(book))
+	writeJSON(w, 200, book)
+}
+
+// handlePurgeFromTrash removes a soft-deleted book permanently without
+// waiting for the retention period.
+func handlePurgeFromTrash(w http.ResponseWriter, r *http.Request) {
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	id := r.PathValue("id")
+	if _, err := getTrashedBook(id); err != nil {
+		writeError(w, 404, "Book not in trash")
+		return
+	}
+	if err := storeAs(actorFromContext(r.Context())).Delete(id); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// purgeTrash permanently removes the books deleted before cutoff and
+// returns how many were removed.
+func purgeTrash(cutoff time.Time) (int, error) {
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	books, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	st := storeAs("retention")
+	purged := 0
+	for _, b := range books {
+		if b.Deleted() && b.DeletedAt.Before(cutoff) {
+			if err := st.Delete(b.Id); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// runTrashPurger purges expired books every interval until ctx is done.
+func runTrashPurger(ctx context.Context, interval time.Duration) {
+	if trashRetention <= 0 || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := purgeTrash(time.Now().Add(-trashRetention))
+		if err != nil {
+			log.Printf("Error - purge trash: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d book(s) from the trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
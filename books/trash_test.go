@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	srv, _ := newTestServer(t)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	post(t, srv, "/books", `{"id":"b","title":"Emma","author":"Jane Austen","price":"4.50"}`, 201)
+	send(t, srv, "DELETE", "/books/a", "", 204)
+	send(t, srv, "DELETE", "/books/b", "", 204)
+
+	// trashed books are hidden everywhere but in the trash
+	send(t, srv, "GET", "/books/a", "", 404)
+	send(t, srv, "DELETE", "/books/a", "", 404)
+	var listed []Book
+	if err := json.Unmarshal(send(t, srv, "GET", "/books", "", 200), &listed); err != nil || len(listed) != 0 {
+		t.Errorf("GET /books = %+v, %v, want no books", listed, err)
+	}
+	var trash []TrashedBook
+	if err := json.Unmarshal(send(t, srv, "GET", "/trash", "", 200), &trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 2 || trash[0].Id != "b" || trash[0].PurgeAt == nil || !trash[0].PurgeAt.Equal(trash[0].DeletedAt.Add(trashRetention)) {
+		t.Errorf("trash = %+v, want b then a with their purge times", trash)
+	}
+
+	var restored Book
+	if err := json.Unmarshal(post(t, srv, "/trash/a/restore", "", 200), &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Deleted() || restored.Title != "Dune" {
+		t.Errorf("restored = %+v", restored)
+	}
+	send(t, srv, "GET", "/books/a", "", 200)
+	post(t, srv, "/trash/a/restore", "", 404)
+
+	// only books in the trash can be purged
+	send(t, srv, "DELETE", "/trash/a", "", 404)
+	send(t, srv, "DELETE", "/trash/b", "", 204)
+	send(t, srv, "DELETE", "/trash/b", "", 404)
+	if _, err := store.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("purged book: %v, want ErrNotFound", err)
+	}
+	if err := json.Unmarshal(send(t, srv, "GET", "/trash", "", 200), &trash); err != nil || len(trash) != 0 {
+		t.Errorf("trash = %+v, %v, want it empty", trash, err)
+	}
+
+	events, err := audit.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.BookId+" "+e.Action)
+	}
+	want := []string{"a create", "b create", "a delete", "b delete", "a restore", "b purge"}
+	if !slices.Equal(actions, want) {
+		t.Errorf("audit = %v, want %v", actions, want)
+	}
+}
+
+func TestPurgeTrashAfterRetention(t *testing.T) {
+	srv, _ := newTestServer(t)
+	now := time.Now().UTC()
+	for id, deleted := range map[string]time.Time{"old": now.Add(-48 * time.Hour), "recent": now.Add(-time.Hour), "live": {}} {
+		book := Book{Id: id, Title: id, Author: "Anon", Price: Money{Amount: 100, Currency: "USD"}, DeletedAt: deleted}
+		if err := store.Create(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := purgeTrash(now.Add(-24 * time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("purgeTrash = %d, %v, want 1 book purged", n, err)
+	}
+	for id, want := range map[string]error{"old": ErrNotFound, "recent": nil, "live": nil} {
+		if _, err := store.Get(id); !errors.Is(err, want) {
+			t.Errorf("%s: %v, want %v", id, err, want)
+		}
+	}
+	if events, _ := audit.read(); len(events) != 1 || events[0].Actor != "retention" || events[0].Action != actionPurge {
+		t.Errorf("audit = %+v, want one purge by retention", events)
+	}
+
+	// without retention nothing expires
+	saved := trashRetention
+	trashRetention = 0
+	t.Cleanup(func() { trashRetention = saved })
+	var trash []TrashedBook
+	if err := json.Unmarshal(send(t, srv, "GET", "/trash", "", 200), &trash); err != nil || len(trash) != 1 || trash[0].PurgeAt != nil {
+		t.Errorf("trash without retention = %+v, %v, want recent without purge_at", trash, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// FieldError describes a single problem with a field of a request body.
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+// priceRe accepts a decimal amount with up to two fractional digits,
+// optionally followed by an ISO 4217 currency code, e.g. "600" or
+// "12.50 EUR".
+var priceRe = regexp.MustCompile(`^\d+(\.\d{1,2})?( [A-Z]{3})?$`)
+
+// currencyRe accepts an ISO 4217 currency code.
+var currencyRe = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Validate reports every field of b that does not satisfy the Book schema.
+// It returns nil when the book is valid.
+func (b Book) Validate() []FieldError {
+	var errs []FieldError
+	if b.Id == "" {
+		errs = append(errs, FieldError{"id", "is required"})
+	}
+	if b.Title == "" {
+		errs = append(errs, FieldError{"title", "is required"})
+	}
+	if b.Author == "" && b.AuthorId == "" {
+		errs = append(errs, FieldError{"author", "is required unless author_id is given"})
+	}
+	if b.Price.invalid != "" {
+		errs = append(errs, FieldError{"price", `must be an amount with up to two decimals and an ISO 4217 currency, e.g. {"amount":"12.50","currency":"EUR"}`})
+	}
+	if b.Imageurl != "" && !isHTTPURL(b.Imageurl) {
+		errs = append(errs, FieldError{"image_url", "must be an absolute http(s) URL"})
+	}
+	inv := b.Inventory
+	if inv.OnHand < 0 || inv.Reserved < 0 || inv.ReorderThreshold < 0 {
+		errs = append(errs, FieldError{"inventory", "quantities must not be negative"})
+	} else if inv.Reserved > inv.OnHand {
+		errs = append(errs, FieldError{"inventory.reserved", "must not exceed on_hand"})
+	}
+	return errs
+}
+
+// validateBooks validates a batch and prefixes each field with the index
+// of the offending book, e.g. "[2].title".
+func validateBooks(books []Book) []FieldError {
+	var errs []FieldError
+	for i, b := range books {
+		for _, fe := range b.Validate() {
+			fe.Field = fmt.Sprintf("[%d].%s", i, fe.Field)
+			errs = append(errs, fe)
+		}
+	}
+	return errs
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestBookValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []string
+	}{
+		{"valid", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, nil},
+		{"author by id", `{"id":"a","title":"Dune","author_id":"x"}`, nil},
+		{"missing fields", `{}`, []string{"id", "title", "author"}},
+		{"bad price", `{"id":"a","title":"Dune","author":"F","price":"9.999"}`, []string{"price"}},
+		{"bad currency", `{"id":"a","title":"Dune","author":"F","price":{"amount":"1.00","currency":"usd"}}`, []string{"price"}},
+		{"relative image", `{"id":"a","title":"Dune","author":"F","image_url":"/covers/a.jpg"}`, []string{"image_url"}},
+		{"negative stock", `{"id":"a","title":"Dune","author":"F","inventory":{"on_hand":-1}}`, []string{"inventory"}},
+		{"over-reserved", `{"id":"a","title":"Dune","author":"F","inventory":{"on_hand":1,"reserved":2}}`, []string{"inventory.reserved"}},
+	}
+	for _, tt := range tests {
+		var b Book
+		if err := json.Unmarshal([]byte(tt.json), &b); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, fe := range b.Validate() {
+			got = append(got, fe.Field)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: fields %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidationErrorResponses(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	body := post(t, srv, "/books", `{"title":"","author":"F","price":"abc"}`, 400)
+	if got := fieldsOf(t, body); !slices.Equal(got, []string{"title", "price"}) {
+		t.Errorf("POST /books: fields %q, want title and price", got)
+	}
+
+	// bulk requests name the offending book by its index
+	body = post(t, srv, "/add", `[{"title":"Dune","author":"F","price":"1.00"},{"author":"F"}]`, 400)
+	if got := fieldsOf(t, body); !slices.Equal(got, []string{"[1].title"}) {
+		t.Errorf("POST /add: fields %q, want [1].title", got)
+	}
+
+	post(t, srv, "/books", `{"title":`, 400)
+	post(t, srv, "/add", `{"title":"not an array"}`, 400)
+}
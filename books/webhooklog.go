@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+)
+
+// webhookLog keeps the webhooks and their deliveries apart from the
+// catalog, in memory and in an append-only NDJSON file like the audit
+// log: every change appends one line, and the file is rewritten with only
+// the current state once it has grown to mostly superseded lines.
+type webhookLog struct {
+	mu         sync.Mutex
+	path       string
+	hooks      []Webhook  // oldest first
+	deliveries []Delivery // oldest first
+	lines      int        // lines in the file
+}
+
+// webhookLogEntry is one line of the file. Exactly one field is set; a
+// saved webhook or delivery replaces the one with the same id.
+type webhookLogEntry struct {
+	Webhook        *Webhook  `json:"webhook,omitempty"`
+	Delivery       *Delivery `json:"delivery,omitempty"`
+	DeleteWebhook  string    `json:"delete_webhook,omitempty"` // with its deliveries
+	DeleteDelivery string    `json:"delete_delivery,omitempty"`
+}
+
+// hookLog is opened in main.
+var hookLog *webhookLog
+
+// openWebhookLog opens (or creates) the log at path and compacts it.
+func openWebhookLog(path string) (*webhookLog, error) {
+	l := &webhookLog{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var entry webhookLogEntry
+		if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("webhook log line %d: %w", n, err)
+		}
+		l.apply(entry)
+		l.lines++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if l.lines > len(l.hooks)+len(l.deliveries) {
+		if err := l.compact(); err != nil {
+			return nil, fmt.Errorf("compact webhook log: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// apply changes the state in memory as entry says.
+func (l *webhookLog) apply(entry webhookLogEntry) {
+	switch {
+	case entry.Webhook != nil:
+		l.hooks = upsertByID(l.hooks, *entry.Webhook, func(h Webhook) string { return h.Id })
+	case entry.Delivery != nil:
+		l.deliveries = upsertByID(l.deliveries, *entry.Delivery, func(d Delivery) string { return d.Id })
+	case entry.DeleteWebhook != "":
+		l.hooks = slices.DeleteFunc(l.hooks, func(h Webhook) bool { return h.Id == entry.DeleteWebhook })
+		l.deliveries = slices.DeleteFunc(l.deliveries, func(d Delivery) bool { return d.WebhookId == entry.DeleteWebhook })
+	case entry.DeleteDelivery != "":
+		l.deliveries = slices.DeleteFunc(l.deliveries, func(d Delivery) bool { return d.Id == entry.DeleteDelivery })
+	}
+}
+
+// write appends entry to the file and applies it. The caller must hold
+// l.mu.
+func (l *webhookLog) write(entry webhookLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	l.apply(entry)
+	l.lines++
+
+	// every attempt appends a line; keep the file near its live size
+	if live := len(l.hooks) + len(l.deliveries); l.lines > 2*live+100 {
+		if err := l.compact(); err != nil {
+			log.Printf("Error - compact webhook log: %v", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the file with one line per webhook and delivery. The
+// caller must hold l.mu unless l is not shared yet.
+func (l *webhookLog) compact() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range l.hooks {
+		if err := enc.Encode(webhookLogEntry{Webhook: &l.hooks[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range l.deliveries {
+		if err := enc.Encode(webhookLogEntry{Delivery: &l.deliveries[i]}); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(l.path, buf.Bytes()); err != nil {
+		return err
+	}
+	l.lines = len(l.hooks) + len(l.deliveries)
+	return nil
+}
+
+// ListWebhooks returns every webhook subscription, oldest first.
+func (l *webhookLog) ListWebhooks() ([]Webhook, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hooks := make([]Webhook, len(l.hooks))
+	for i, h := range l.hooks {
+		hooks[i] = h.clone()
+	}
+	return hooks, nil
+}
+
+// GetWebhook returns the webhook with the given id or ErrNotFound.
+func (l *webhookLog) GetWebhook(id string) (Webhook, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hook, err := findByID(l.hooks, id, func(h Webhook) string { return h.Id })
+	return hook.clone(), err
+}
+
+// SaveWebhook creates or replaces a webhook.
+func (l *webhookLog) SaveWebhook(hook Webhook) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hook = hook.clone()
+	return l.write(webhookLogEntry{Webhook: &hook})
+}
+
+// DeleteWebhook removes the webhook with the given id and its
+// deliveries, or returns ErrNotFound.
+func (l *webhookLog) DeleteWebhook(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !slices.ContainsFunc(l.hooks, func(h Webhook) bool { return h.Id == id }) {
+		return ErrNotFound
+	}
+	return l.write(webhookLogEntry{DeleteWebhook: id})
+}
+
+// ListDeliveries returns the deliveries of the webhook with the given
+// id, or of every webhook if webhookId is empty, oldest first.
+func (l *webhookLog) ListDeliveries(webhookId string) ([]Delivery, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	deliveries := []Delivery{}
+	for _, d := range l.deliveries {
+		if webhookId == "" || d.WebhookId == webhookId {
+			deliveries = append(deliveries, d.clone())
+		}
+	}
+	return deliveries, nil
+}
+
+// SaveDelivery creates or replaces a delivery.
+func (l *webhookLog) SaveDelivery(d Delivery) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	d = d.clone()
+	return l.write(webhookLogEntry{Delivery: &d})
+}
+
+// DeleteDelivery removes the delivery with the given id or returns
+// ErrNotFound.
+func (l *webhookLog) DeleteDelivery(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !slices.ContainsFunc(l.deliveries, func(d Delivery) bool { return d.Id == id }) {
+		return ErrNotFound
+	}
+	return l.write(webhookLogEntry{DeleteDelivery: id})
+}
+
+// clone copies the slices of hook, so the copy kept by the log is not
+// shared with callers.
+func (hook Webhook) clone() Webhook {
+	hook.Events = slices.Clone(hook.Events)
+	return hook
+}
+
+// clone copies the slices of d, see Webhook.clone.
+func (d Delivery) clone() Delivery {
+	d.Payload = slices.Clone(d.Payload)
+	d.Attempts = slices.Clone(d.Attempts)
+	return d
+}
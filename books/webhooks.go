@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// webhookEvents maps the audit actions to the events webhooks subscribe
+// to.
+var webhookEvents = map[string]string{
+	actionCreate:  "book.created",
+	actionUpdate:  "book.updated",
+	actionDelete:  "book.deleted", // moved to the trash
+	actionRestore: "book.restored",
+	actionPurge:   "book.purged",
+}
+
+// Delivery states.
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// Webhook is a subscription to catalog changes. Every matching change is
+// POSTed to URL as a WebhookPayload signed with Secret, see
+// signWebhookPayload.
+type Webhook struct {
+	Id        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`           // empty subscribes to every event
+	Secret    string    `json:"secret,omitempty"` // only shown when the webhook is created
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookPayload is the JSON body sent to webhooks. Book is the book after
+// the change, or before it for book.purged.
+type WebhookPayload struct {
+	Event   string        `json:"event"`
+	Seq     int64         `json:"seq"` // audit event, see /books/{id}/history
+	Time    time.Time     `json:"time"`
+	Actor   string        `json:"actor"`
+	Book    *Book         `json:"book"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// changePayload describes ev to webhooks and event streams.
+func changePayload(ev AuditEvent) WebhookPayload {
+	book := ev.After
+	if book == nil {
+		book = ev.Before
+	}
+	return WebhookPayload{webhookEvents[ev.Action], ev.Seq, ev.Time, ev.Actor, book, ev.Changes}
+}
+
+// Delivery is one payload on its way to one webhook, with every attempt
+// made so far.
+type Delivery struct {
+	Id           string            `json:"id"`
+	WebhookId    string            `json:"webhook_id"`
+	Event        string            `json:"event"`
+	Payload      json.RawMessage   `json:"payload"`
+	Status       string            `json:"status"` // pending|succeeded|failed
+	Attempts     []DeliveryAttempt `json:"attempts"`
+	NextAttempt  time.Time         `json:"next_attempt,omitzero"`   // while pending
+	RedeliveryOf string            `json:"redelivery_of,omitempty"` // delivery sent again
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// DeliveryAttempt is the outcome of one POST to a webhook.
+type DeliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// webhookInput is the body of POST and PUT /webhooks. A PUT without a
+// secret keeps the current one.
+type webhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"` // default true
+}
+
+// maxDeliveryLog is the number of finished deliveries kept per webhook.
+const maxDeliveryLog = 100
+
+// webhookWorkers is the number of deliveries attempted at once.
+const webhookWorkers = 4
+
+// maxWebhookAttempts bounds -webhook-attempts.
+const maxWebhookAttempts = 50
+
+// maxRetryDelay caps the doubling delay between delivery attempts.
+const maxRetryDelay = 24 * time.Hour
+
+// errWebhookInactive is the error of deliveries to a deactivated webhook.
+var errWebhookInactive = errors.New("webhook is inactive")
+
+// webhooks is started in main; when nil no deliveries are made.
+var webhooks *webhookDispatcher
+
+// webhookDispatcher turns audit events into deliveries and attempts them
+// in the background, retrying failures with exponential backoff. Pending
+// deliveries are stored, so they are resumed after a restart.
+type webhookDispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration // delay before the first retry, doubled after every failure
+
+	events chan AuditEvent
+	due    chan Delivery
+	ctx    context.Context // stops the dispatcher
+}
+
+func newWebhookDispatcher(ctx context.Context, timeout time.Duration, maxAttempts int, backoff time.Duration) *webhookDispatcher {
+	return &webhookDispatcher{
+		ctx:         ctx,
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		events:      make(chan AuditEvent, 1024),
+		due:         make(chan Delivery, 1024),
+	}
+}
+
+// publish queues ev for delivery. It is called by the audit log and must
+// not block, so events are dropped when the queue is full.
+func (d *webhookDispatcher) publish(ev AuditEvent) {
+	select {
+	case d.events <- ev:
+	default:
+		log.Printf("Error - webhook queue full, dropping event %d", ev.Seq)
+	}
+}
+
+// run delivers events until the dispatcher's context is done. Attempts
+// cut short by the shutdown stay pending.
+func (d *webhookDispatcher) run() {
+	ctx := d.ctx
+	for range webhookWorkers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-d.due:
+					d.attempt(delivery)
+				}
+			}
+		}()
+	}
+
+	pending, err := hookLog.ListDeliveries("")
+	if err != nil {
+		log.Printf("Error - resume webhook deliveries: %v", err)
+	}
+	for _, delivery := range pending {
+		if delivery.Status == deliveryPending {
+			d.schedule(delivery)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-d.events:
+			if err := d.enqueue(ev); err != nil {
+				log.Printf("Error - webhook event %d: %v", ev.Seq, err)
+			}
+		}
+	}
+}
+
+// enqueue stores a delivery of ev for every active webhook subscribed to
+// its event.
+func (d *webhookDispatcher) enqueue(ev AuditEvent) error {
+	event := webhookEvents[ev.Action]
+	hooks, err := hookLog.ListWebhooks()
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	payload, err := json.Marshal(changePayload(ev))
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if !hook.Active || (len(hook.Events) > 0 && !slices.Contains(hook.Events, event)) {
+			continue
+		}
+		delivery := Delivery{
+			Id:          newID(),
+			WebhookId:   hook.Id,
+			Event:       event,
+			Payload:     payload,
+			Status:      deliveryPending,
+			Attempts:    []DeliveryAttempt{},
+			NextAttempt: time.Now().UTC(),
+			CreatedAt:   time.Now().UTC(),
+		}
+		if err := hookLog.SaveDelivery(delivery); err != nil {
+			return err
+		}
+		d.schedule(delivery)
+	}
+	return nil
+}
+
+// schedule hands delivery to a worker once its next attempt is due.
+func (d *webhookDispatcher) schedule(delivery Delivery) {
+	time.AfterFunc(time.Until(delivery.NextAttempt), func() {
+		select {
+		case d.due <- delivery:
+		case <-d.ctx.Done():
+		}
+	})
+}
+
+// attempt POSTs delivery to its webhook and records the outcome. The
+// deliveries of a webhook that was deactivated meanwhile fail without a
+// request.
+func (d *webhookDispatcher) attempt(delivery Delivery) {
+	hook, err := hookLog.GetWebhook(delivery.WebhookId)
+	if errors.Is(err, ErrNotFound) {
+		return // deleted together with its deliveries
+	}
+	if err != nil {
+		log.Printf("Error - webhook delivery %s: %v", delivery.Id, err)
+		return
+	}
+
+	var try DeliveryAttempt
+	if hook.Active {
+		try = d.post(hook, delivery)
+	} else {
+		try = DeliveryAttempt{Time: time.Now().UTC(), Error: errWebhookInactive.Error()}
+	}
+	if d.ctx.Err() != nil {
+		return // shutting down; the delivery is resumed after the restart
+	}
+	delivery.Attempts = append(delivery.Attempts, try)
+	switch {
+	case !hook.Active:
+		delivery.Status, delivery.NextAttempt = deliveryFailed, time.Time{}
+	case try.Error == "" && try.StatusCode < 300:
+		delivery.Status, delivery.NextAttempt = deliverySucceeded, time.Time{}
+	case len(delivery.Attempts) >= d.maxAttempts:
+		delivery.Status, delivery.NextAttempt = deliveryFailed, time.Time{}
+	default:
+		delivery.NextAttempt = time.Now().UTC().Add(d.retryDelay(len(delivery.Attempts)))
+	}
+	if err := hookLog.SaveDelivery(delivery); err != nil {
+		log.Printf("Error - webhook delivery %s: %v", delivery.Id, err)
+		return
+	}
+	if delivery.Status == deliveryPending {
+		d.schedule(delivery)
+		return
+	}
+	if err := pruneDeliveries(hook.Id); err != nil {
+		log.Printf("Error - prune deliveries of %s: %v", hook.Id, err)
+	}
+}
+
+// retryDelay returns the delay after the given number of failed
+// attempts: the backoff, doubled after every failure but the first, and
+// at most maxRetryDelay.
+func (d *webhookDispatcher) retryDelay(failures int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// post sends one attempt. Any response outside 2xx counts as a failure.
+func (d *webhookDispatcher) post(hook Webhook, delivery Delivery) DeliveryAttempt {
+	start := time.Now()
+	try := DeliveryAttempt{Time: start.UTC()}
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "books-webhooks")
+		req.Header.Set("X-Books-Event", delivery.Event)
+		req.Header.Set("X-Books-Delivery", delivery.Id)
+		req.Header.Set("X-Books-Signature", signWebhookPayload(hook.Secret, start.Unix(), delivery.Payload))
+		var resp *http.Response
+		if resp, err = d.client.Do(req); err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			try.StatusCode = resp.StatusCode
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				try.Error = resp.Status
+			}
+		}
+	}
+	if err != nil {
+		try.Error = err.Error()
+	}
+	try.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	return try
+}
+
+// signWebhookPayload returns the X-Books-Signature header value
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">". Receivers
+// recompute the HMAC with their secret and should reject old timestamps
+// to prevent replays.
+func signWebhookPayload(secret string, unix int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", unix)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", unix, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// pruneDeliveries keeps the maxDeliveryLog most recent finished
+// deliveries of a webhook.
+func pruneDeliveries(webhookId string) error {
+	deliveries, err := hookLog.ListDeliveries(webhookId)
+	if err != nil {
+		return err
+	}
+	finished := slices.DeleteFunc(deliveries, func(d Delivery) bool { return d.Status == deliveryPending })
+	for len(finished) > maxDeliveryLog {
+		if err := hookLog.DeleteDelivery(finished[0].Id); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		finished = finished[1:]
+	}
+	return nil
+}
+
+// newWebhookSecret returns a random secret for webhooks created without
+// one.
+func newWebhookSecret() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// apply validates in and copies it onto hook.
+func (in webhookInput) apply(hook *Webhook) []FieldError {
+	var errs []FieldError
+	if !isHTTPURL(in.URL) {
+		errs = append(errs, FieldError{"url", "must be an absolute http(s) URL"})
+	}
+	for _, event := range in.Events {
+		known := false
+		for _, e := range webhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			errs = append(errs, FieldError{"events", "unknown event " + strconv.Quote(event)})
+		}
+	}
+	hook.URL = in.URL
+	hook.Events = slices.Compact(slices.Sorted(slices.Values(in.Events)))
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+	if in.Secret != "" {
+		hook.Secret = in.Secret
+	}
+	hook.Active = in.Active == nil || *in.Active
+	return errs
+}
+
+// withoutSecret returns hook as listed by the API.
+func (hook Webhook) withoutSecret() Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// requireWriteScope answers 403 unless the caller may manage webhooks;
+// their URLs are not meant for public reads.
+func requireWriteScope(w http.ResponseWriter, r *http.Request) bool {
+	if !hasWriteScope(r) {
+		writeError(w, 403, "Forbidden: webhooks need credentials with the write scope")
+		return false
+	}
+	return true
+}
+
+func writeWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, 404, "Webhook not found")
+		return
+	}
+	writeStoreError(w, r, err)
+}
+
+func handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !requireWriteScope(w, r) {
+		return
+	}
+	hooks, err := hookLog.ListWebhooks()
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	for i := range hooks {
+		hooks[i] = hooks[i].withoutSecret()
+	}
+	writeJSON(w, 200, hooks)
+}
+
+// handleCreateWebhook subscribes a URL. The response is the only one that
+// shows the secret.
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var in webhookInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+	hook := Webhook{Id: newID(), Secret: newWebhookSecret(), CreatedAt: time.Now().UTC()}
+	if fieldErrs := in.apply(&hook); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+	if err := hookLog.SaveWebhook(hook); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/webhooks/"+url.PathEscape(hook.Id))
+	writeJSON(w, 201, hook)
+}
+
+func handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireWriteScope(w, r) {
+		return
+	}
+	hook, err := hookLog.GetWebhook(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, 200, hook.withoutSecret())
+}
+
+// handleReplaceWebhook changes the URL, events, secret or active flag of
+// a webhook. Pending deliveries go to the new URL.
+func handleReplaceWebhook(w http.ResponseWriter, r *http.Request) {
+	var in webhookInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeBodyError(w, err, "Bad Request")
+		return
+	}
+
+	mutateMu.Lock()
+	defer mutateMu.Unlock()
+
+	hook, err := hookLog.GetWebhook(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	if fieldErrs := in.apply(&hook); fieldErrs != nil {
+		writeError(w, 400, "Validation failed", fieldErrs...)
+		return
+	}
+	if err := hookLog.SaveWebhook(hook); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	writeJSON(w, 200, hook.withoutSecret())
+}
+
+// handleDeleteWebhook unsubscribes and drops the delivery log.
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := hookLog.DeleteWebhook(r.PathValue("id")); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handleListDeliveries lists the deliveries of a webhook, newest first,
+// optionally only those with the given ?status=.
+func handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !requireWriteScope(w, r) {
+		return
+	}
+	limit, offset, fieldErrs := parsePage(r.URL.Query())
+	status := r.URL.Query().Get("status")
+	if status != "" && status != deliveryPending && status != deliverySucceeded && status != deliveryFailed {
+		fieldErrs = append(fieldErrs, FieldError{"status", "must be pending, succeeded or failed"})
+	}
+	if fieldErrs != nil {
+		writeError(w, 400, "Invalid query parameters", fieldErrs...)
+		return
+	}
+	hook, err := hookLog.GetWebhook(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	deliveries, err := hookLog.ListDeliveries(hook.Id)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	matched := []Delivery{}
+	for _, d := range slices.Backward(deliveries) {
+		if status == "" || d.Status == status {
+			matched = append(matched, d)
+		}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(matched)))
+	writeJSON(w, 200, page(matched, limit, offset))
+}
+
+// handleRedeliver sends the payload of a finished delivery again as a new
+// delivery.
+func handleRedeliver(w http.ResponseWriter, r *http.Request) {
+	if webhooks == nil {
+		writeError(w, 503, "Webhook deliveries are not running")
+		return
+	}
+	hook, err := hookLog.GetWebhook(r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	if !hook.Active {
+		writeError(w, 409, "Webhook is inactive")
+		return
+	}
+	deliveries, err := hookLog.ListDeliveries(hook.Id)
+	if err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	i := slices.IndexFunc(deliveries, func(d Delivery) bool { return d.Id == r.PathValue("deliveryId") })
+	if i < 0 {
+		writeError(w, 404, "Delivery not found")
+		return
+	}
+	if deliveries[i].Status == deliveryPending {
+		writeError(w, 409, "Delivery is still pending")
+		return
+	}
+	now := time.Now().UTC()
+	delivery := deliveries[i]
+	delivery.RedeliveryOf = delivery.Id
+	delivery.Id = newID()
+	delivery.Status = deliveryPending
+	delivery.Attempts = []DeliveryAttempt{}
+	delivery.NextAttempt, delivery.CreatedAt = now, now
+	if err := hookLog.SaveDelivery(delivery); err != nil {
+		writeWebhookError(w, r, err)
+		return
+	}
+	webhooks.schedule(delivery)
+	w.Header().Set("Location", "/webhooks/"+url.PathEscape(delivery.WebhookId)+"/deliveries")
+	writeJSON(w, 202, delivery)
+}
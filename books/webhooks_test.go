@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	got := signWebhookPayload("s3cret", 1700000000, []byte(`{"event":"book.created"}`))
+	want := "t=1700000000,v1=3f1757778563cde97e76157eda8355e3ca03ccd9abf49ed745f623b370a28e33"
+	if got != want {
+		t.Errorf("signWebhookPayload = %s, want %s", got, want)
+	}
+}
+
+// startWebhooks runs a dispatcher for the test server until the test
+// ends and subscribes a webhook with the given URL to every event.
+func startWebhooks(t *testing.T, url string, attempts int, backoff time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d := newWebhookDispatcher(ctx, time.Second, attempts, backoff)
+	audit.subscribe(d.publish)
+	go d.run()
+
+	hook := Webhook{Id: "hook", URL: url, Events: []string{}, Secret: "s3cret", Active: true, CreatedAt: time.Now().UTC()}
+	if err := hookLog.SaveWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// finishedDelivery waits for the only delivery of the test webhook to
+// succeed or fail.
+func finishedDelivery(t *testing.T) Delivery {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		deliveries, err := hookLog.ListDeliveries("hook")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status != deliveryPending {
+			return deliveries[0]
+		}
+	}
+	t.Fatal("no finished delivery")
+	return Delivery{}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	srv, _ := newTestServer(t)
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var unix int64
+		var sig string
+		fmt.Sscanf(strings.ReplaceAll(r.Header.Get("X-Books-Signature"), ",", " "), "t=%d v1=%s", &unix, &sig)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		fmt.Fprintf(mac, "%d.%s", unix, body)
+		if want := hex.EncodeToString(mac.Sum(nil)); sig != want {
+			t.Errorf("signature %q does not match the body", r.Header.Get("X-Books-Signature"))
+		}
+		if r.Header.Get("X-Books-Event") != "book.created" {
+			t.Errorf("X-Books-Event = %q, want book.created", r.Header.Get("X-Books-Event"))
+		}
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer receiver.Close()
+
+	const backoff = 20 * time.Millisecond
+	startWebhooks(t, receiver.URL, 5, backoff)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+
+	d := finishedDelivery(t)
+	if d.Status != deliverySucceeded || len(d.Attempts) != 3 {
+		t.Fatalf("delivery %s after %d attempts, want succeeded after 3", d.Status, len(d.Attempts))
+	}
+	for i, want := range []int{500, 500, 200} {
+		if got := d.Attempts[i].StatusCode; got != want {
+			t.Errorf("attempt %d: status %d, want %d", i+1, got, want)
+		}
+	}
+	// the delay doubles after every failure
+	for i, want := range []time.Duration{backoff, 2 * backoff} {
+		if gap := d.Attempts[i+1].Time.Sub(d.Attempts[i].Time); gap < want {
+			t.Errorf("retry %d after %v, want at least %v", i+1, gap, want)
+		}
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(d.Payload, &payload); err != nil || payload.Book == nil || payload.Book.Id != "a" {
+		t.Errorf("payload = %s, want book a", d.Payload)
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	tests := []struct {
+		backoff  time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{10 * time.Second, 1, 10 * time.Second},
+		{10 * time.Second, 2, 20 * time.Second},
+		{10 * time.Second, 5, 160 * time.Second},
+		{10 * time.Second, 14, 22*time.Hour + 45*time.Minute + 20*time.Second},
+		{10 * time.Second, 15, maxRetryDelay},
+		{10 * time.Second, maxWebhookAttempts, maxRetryDelay},
+		{10 * time.Second, 100, maxRetryDelay}, // a plain shift overflows here
+		{48 * time.Hour, 1, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		d := newWebhookDispatcher(context.Background(), time.Second, maxWebhookAttempts, tt.backoff)
+		if got := d.retryDelay(tt.failures); got != tt.want {
+			t.Errorf("retryDelay(%d) with backoff %v = %v, want %v", tt.failures, tt.backoff, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	srv, _ := newTestServer(t)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer receiver.Close()
+
+	startWebhooks(t, receiver.URL, 3, time.Millisecond)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+
+	d := finishedDelivery(t)
+	if d.Status != deliveryFailed || len(d.Attempts) != 3 {
+		t.Errorf("delivery %s after %d attempts, want failed after 3", d.Status, len(d.Attempts))
+	}
+}
+
+func TestInactiveWebhookIsNotCalled(t *testing.T) {
+	newTestServer(t)
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	// deactivated after the delivery was queued
+	d := newWebhookDispatcher(context.Background(), time.Second, 3, time.Millisecond)
+	hook := Webhook{Id: "hook", URL: receiver.URL, Events: []string{}, Active: false}
+	delivery := Delivery{Id: "d", WebhookId: "hook", Event: "book.created", Payload: json.RawMessage(`{}`), Status: deliveryPending, Attempts: []DeliveryAttempt{}}
+	if err := hookLog.SaveWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+	if err := hookLog.SaveDelivery(delivery); err != nil {
+		t.Fatal(err)
+	}
+	d.attempt(delivery)
+
+	if n := calls.Load(); n != 0 {
+		t.Errorf("inactive webhook called %d times", n)
+	}
+	got := finishedDelivery(t)
+	if got.Status != deliveryFailed || len(got.Attempts) != 1 || got.Attempts[0].Error != errWebhookInactive.Error() {
+		t.Errorf("delivery = %+v, want failed as inactive", got)
+	}
+}
+
+func TestWebhookDeliveryLog(t *testing.T) {
+	srv, path := newTestServer(t)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	startWebhooks(t, receiver.URL, 3, time.Millisecond)
+	post(t, srv, "/books", `{"id":"a","title":"Dune","author":"Frank Herbert","price":"9.99"}`, 201)
+	want := finishedDelivery(t)
+
+	// served newest first to credentials with the write scope
+	req := httptest.NewRequest("GET", "/webhooks/hook/deliveries", nil)
+	req.SetPathValue("id", "hook")
+	req = req.WithContext(context.WithValue(req.Context(), principalKey{}, principal{"test", scopeWrite}))
+	rec := httptest.NewRecorder()
+	handleListDeliveries(rec, req)
+	var listed []Delivery
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || rec.Code != 200 {
+		t.Fatalf("GET deliveries: %d %s", rec.Code, rec.Body)
+	}
+	if len(listed) != 1 || listed[0].Id != want.Id || listed[0].Status != deliverySucceeded {
+		t.Errorf("listed %+v, want the succeeded delivery %s", listed, want.Id)
+	}
+
+	// kept in their own file, which survives a restart, not in the catalog
+	reopened, err := openWebhookLog(hookLog.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deliveries, _ := reopened.ListDeliveries("hook"); len(deliveries) != 1 || deliveries[0].Status != deliverySucceeded {
+		t.Errorf("after reopening: %+v", deliveries)
+	}
+	catalog, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(catalog), want.Id) {
+		t.Errorf("%s holds the delivery: %s", path, catalog)
+	}
+
+	// deleting the webhook drops its deliveries
+	if err := hookLog.DeleteWebhook("hook"); err != nil {
+		t.Fatal(err)
+	}
+	if reopened, err = openWebhookLog(hookLog.path); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries, _ := reopened.ListDeliveries(""); len(deliveries) != 0 {
+		t.Errorf("deliveries of a deleted webhook: %+v", deliveries)
+	}
+}